@@ -0,0 +1,81 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+// Command ca manages the internal CA of configNexus: it creates one-time
+// bootstrap tokens hosts enroll with, and revokes issued certificates. It
+// works on the same CADir as the server, which picks up the changes
+// without a restart.
+//
+//	configNexus-ca -dir /var/lib/configNexus/ca token web1.example.com
+//	configNexus-ca -dir /var/lib/configNexus/ca -ttl 1h token '*.dc1.example.com'
+//	configNexus-ca -dir /var/lib/configNexus/ca revoke 5f3a...
+package main
+
+import (
+	"configNexus/internal/utils"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", os.Getenv("CN_CADIR"), "CA `directory`, the CADir of the server (default $CN_CADIR)")
+	ttl := flag.Duration("ttl", 24*time.Hour, "how long a new bootstrap token can be used")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -dir ca-dir token <hostname glob> | revoke <serial>\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *dir == "" {
+		fail("the CA directory is needed, see -dir")
+	}
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch flag.Arg(0) {
+	case "token":
+		token, err := utils.CreateBootstrapToken(*dir, flag.Arg(1), *ttl)
+		if err != nil {
+			fail(err.Error())
+		}
+		fmt.Println(token)
+	case "revoke":
+		// Accept the colon separated form openssl and browsers show
+		serial, ok := new(big.Int).SetString(strings.ReplaceAll(flag.Arg(1), ":", ""), 16)
+		if !ok {
+			fail("the serial must be hexadecimal")
+		}
+		if err := utils.RevokeCertificate(*dir, serial); err != nil {
+			fail(err.Error())
+		}
+	default:
+		fail("unknown command " + flag.Arg(0))
+	}
+}
+
+func fail(message string) {
+	fmt.Fprintln(os.Stderr, "ca:", message)
+	os.Exit(1)
+}
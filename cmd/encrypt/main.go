@@ -0,0 +1,111 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+// Command encrypt encrypts a value or a whole layer file for the config
+// repository. configNexus decrypts them with the identities in its
+// SecretKeyFile.
+//
+//	configNexus-encrypt -r age1... 's3cr3t'   # prints ENC[age:...]
+//	echo -n 's3cr3t' | configNexus-encrypt -R recipients.txt
+//	configNexus-encrypt -r age1... -file devices/db1.yaml > db1.yaml.age
+package main
+
+import (
+	"configNexus/internal/utils"
+	"filippo.io/age"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	var recipients []age.Recipient
+	flag.Func("r", "age recipient (`age1...`) the secret is encrypted for, can be repeated", func(value string) error {
+		recipient, err := age.ParseX25519Recipient(value)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, recipient)
+		return nil
+	})
+	flag.Func("R", "`file` with one age recipient per line, can be repeated", func(path string) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		parsed, err := age.ParseRecipients(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		recipients = append(recipients, parsed...)
+		return nil
+	})
+	filePath := flag.String("file", "", "encrypt the whole layer `file` instead of a value, the armored result is printed")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -r recipient [-file layer.yaml | value]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Without a value or file the value is read from stdin.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(recipients) == 0 {
+		fail("at least one recipient is needed, see -r and -R")
+	}
+
+	if *filePath != "" {
+		content, err := os.ReadFile(*filePath)
+		if err != nil {
+			fail(err.Error())
+		}
+		encrypted, err := utils.EncryptFile(content, recipients...)
+		if err != nil {
+			fail(err.Error())
+		}
+		os.Stdout.Write(encrypted)
+		return
+	}
+
+	var value []byte
+	switch flag.NArg() {
+	case 0:
+		stdin, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fail(err.Error())
+		}
+		value = []byte(strings.TrimSuffix(string(stdin), "\n"))
+	case 1:
+		value = []byte(flag.Arg(0))
+	default:
+		fail("only a single value can be encrypted at a time")
+	}
+
+	encrypted, err := utils.EncryptValue(value, recipients...)
+	if err != nil {
+		fail(err.Error())
+	}
+	fmt.Println(encrypted)
+}
+
+func fail(message string) {
+	fmt.Fprintln(os.Stderr, "encrypt:", message)
+	os.Exit(1)
+}
@@ -33,7 +33,7 @@ import (
 // Clean temporary files
 func cleanup() {
 	log.Info().Msg("Cleaning Up before Exiting")
-	err := os.RemoveAll(utils.GlobalRepoPath)
+	err := utils.RemoveRepoPath()
 	if err != nil {
 		log.Fatal().Err(err).Msg("Unable to remove temporary folder")
 		os.Exit(1)
@@ -43,7 +43,7 @@ func cleanup() {
 
 func main() {
 	// Catch ^C and try to cleanup tmp files on exit
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -61,11 +61,27 @@ func main() {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
+	if settings.ClientTokensFile != "" {
+		tokens, err := utils.LoadClientTokens(settings.ClientTokensFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load client tokens")
+		}
+		utils.GlobalClientTokens = tokens
+	}
+
+	if settings.CADir != "" {
+		ca, err := utils.LoadCertificateAuthority(settings.CADir, settings.CACertTTL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load the internal CA")
+		}
+		utils.GlobalCertificateAuthority = ca
+	}
+
 	httpEnabled := settings.HTTPEnabled != "false"
 	httpsRedirect := settings.HTTPRedirect != "false"
 
 	defer cleanup()
-	err = utils.ManageRepo(settings.RepoAddress, settings.RepoBranch)
+	err = utils.ManageRepo(settings)
 	if err != nil {
 		log.Fatal().Err(err).Msg("There was a problem with the repo")
 	}
@@ -75,7 +91,7 @@ func main() {
 	}
 
 	// Set up the same handlers for HTTPS
-	httpsMux := handlers.SetupHandlers()
+	httpsMux := handlers.SetupHandlers(settings)
 
 	if httpEnabled {
 		go func() {
@@ -101,10 +117,18 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to generate self-signed certificates")
 	}
 
+	// Client certificates are verified when a client CA bundle is configured
+	tlsConfig, err := utils.ClientTLSConfig(settings, utils.GlobalCertificateAuthority)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up client certificate verification")
+	}
+	httpsServer := &http.Server{Addr: settings.HTTPSAddr, Handler: httpsMux, TLSConfig: tlsConfig}
+
 	log.Info().
 		Str("Port", settings.HTTPSPort).
+		Bool("MutualTLS", tlsConfig != nil).
 		Msg("HTTPS server running")
-	if err := http.ListenAndServeTLS(settings.HTTPSAddr, settings.CertPath, settings.KeyPath, httpsMux); err != nil {
+	if err := httpsServer.ListenAndServeTLS(settings.CertPath, settings.KeyPath); err != nil {
 		log.Fatal().Err(err).Msg("HTTPS startup failed")
 	}
 }
@@ -20,11 +20,9 @@ package handlers
 
 import (
 	"configNexus/internal/utils"
-	"encoding/json"
+	"errors"
 	"github.com/rs/zerolog/log"
 	"net/http"
-	"os"
-	"regexp"
 	"strings"
 )
 
@@ -37,97 +35,63 @@ func DetailsHandler() http.HandlerFunc {
 			http.Error(w, "Missing hostname", http.StatusBadRequest)
 			return
 		}
+		redact, ok := redactRequested(w, r)
+		if !ok {
+			return
+		}
+		identities, ok := clientIdentities(w, r)
+		if !ok {
+			return
+		}
 
-		// Fetch domain patterns using GetDomainPatterns
-		domainPatterns := utils.GetDomainPatterns()
-
-		// Loop through domain patterns and match
-		for _, pattern := range domainPatterns {
-			re, err := regexp.Compile(pattern.Regex)
-			if err != nil {
-				http.Error(w, "Invalid Regex Pattern", http.StatusInternalServerError)
-				return
-			}
-
-			match := re.FindStringSubmatch(hostname)
-			if match != nil {
-				// Create a map to hold the named matched content
-				names := re.SubexpNames()
-				mapped := make(map[string]string)
-				for i, name := range names {
-					if i != 0 && name != "" {
-						mapped[name] = match[i]
-					}
-				}
-
-				// Process the main template
-				mainTemplate, err := utils.ProcessTemplate(utils.GlobalRepoPath+"/all.yaml", mapped)
-				if err != nil {
-					http.Error(w, "Failed to process main template", http.StatusInternalServerError)
-					log.Error().Err(err).Msg("Failed to process main template")
-					return
-				}
-
-				// Process the function-specific template
-				functionTemplatePath := utils.GlobalRepoPath + "/functions/" + mapped["Function"] + ".yaml"
-				if _, err := os.Stat(functionTemplatePath); err == nil {
-					functionTemplate, err := utils.ProcessTemplate(functionTemplatePath, mapped)
-					if err != nil {
-						http.Error(w, "Failed to process function-specific template", http.StatusInternalServerError)
-						log.Error().Err(err).Msg("Failed to process function-specific template")
-						return
-					}
-
-					// Merge mainTemplate and functionTemplate
-					for key, value := range functionTemplate {
-						mainTemplate[key] = value
-					}
-				}
-
-				// Process the datacenter-specific template
-				datacenterTemplatePath := utils.GlobalRepoPath + "/datacenters/" + mapped["Datacenter"] + ".yaml"
-				if _, err := os.Stat(datacenterTemplatePath); err == nil {
-					datacenterTemplate, err := utils.ProcessTemplate(datacenterTemplatePath, mapped)
-					if err != nil {
-						http.Error(w, "Failed to process datacenter-specific template", http.StatusInternalServerError)
-						log.Error().Err(err).Msg("Failed to process datacenter-specific template")
-						return
-					}
-
-					// Merge mainTemplate and datacenterTemplate
-					for key, value := range datacenterTemplate {
-						mainTemplate[key] = value
-					}
-				}
-
-				// Process device specific templates
-				deviceTemplatePath := utils.GlobalRepoPath + "/devices/" + hostname + ".yaml"
-				if _, err := os.Stat(deviceTemplatePath); err == nil {
-					deviceTemplate, err := utils.ProcessTemplate(deviceTemplatePath, mapped)
-					if err != nil {
-						http.Error(w, "Failed to process Device-specific template", http.StatusInternalServerError)
-						log.Error().Err(err).Msg("Failed to process Device-specific template")
-						return
-					}
-					// Merge mainTemplate and datacenterTemplate
-					for key, value := range deviceTemplate {
-						mainTemplate[key] = value
-					}
+		snapshot, ok := acquireSnapshot(w, r)
+		if !ok {
+			return
+		}
+		defer snapshot.Release()
 
-				}
-				// Convert merged map to JSON and send it as a response
-				jsonData, err := json.Marshal(mainTemplate)
-				if err != nil {
-					http.Error(w, "Failed to convert to JSON", http.StatusInternalServerError)
-					return
-				}
+		mainTemplate, ok := renderHost(w, snapshot, hostname, identities)
+		if !ok {
+			return
+		}
 
-				w.Header().Set("Content-Type", "application/json")
-				w.Write(jsonData)
-				return
-			}
+		if redact {
+			mainTemplate = utils.GlobalSecrets.Redact(mainTemplate).(map[string]interface{})
 		}
 
+		// Send the merged map in the format the client asked for
+		writeConfig(w, r, mainTemplate)
+	}
+}
+
+// renderHost matches the hostname against the domain patterns of the
+// snapshot, then processes every layer of the hierarchy and merges them in
+// order. Sensitive keys none of the client identities may read are masked.
+// Failures are reported to the client.
+func renderHost(w http.ResponseWriter, snapshot *utils.Snapshot, hostname string, identities []string) (map[string]interface{}, bool) {
+	match, data, err := utils.RenderHost(snapshot, hostname)
+	var layerErr *utils.LayerError
+	switch {
+	case errors.As(err, &layerErr):
+		writeLayerError(w, err)
+		return nil, false
+	case err != nil:
+		http.Error(w, "Invalid Regex Pattern", http.StatusInternalServerError)
+		return nil, false
+	case match == nil:
 		http.Error(w, "No matching pattern found", http.StatusNotFound)
+		return nil, false
+	}
+	return snapshot.Sensitive.Mask(data, identities), true
+}
+
+// writeLayerError reports a failure to process the hierarchy for a host
+func writeLayerError(w http.ResponseWriter, err error) {
+	var layerErr *utils.LayerError
+	if errors.As(err, &layerErr) {
+		http.Error(w, "Failed to process "+layerErr.Layer+" template", http.StatusInternalServerError)
+	} else {
+		http.Error(w, "Failed to process templates", http.StatusInternalServerError)
 	}
+	log.Error().Err(err).Msg("Failed to process hierarchy")
 }
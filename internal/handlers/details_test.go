@@ -21,30 +21,43 @@ package handlers_test
 import (
 	"configNexus/internal/handlers"
 	"configNexus/internal/utils"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func setup() {
-	utils.GlobalDomainPatternsMutex.Lock()
-	utils.GlobalDomainPatterns = []utils.RegexPattern{
+func testPatterns() []utils.RegexPattern {
+	return []utils.RegexPattern{
 		{
 			Name:  "TestPattern1",
 			Regex: "(?P<Function>fn)-(?P<Datacenter>dc)",
 		},
 	}
-	utils.GlobalDomainPatternsMutex.Unlock()
+}
+
+// activate publishes a snapshot of repo for the handlers to serve.
+func activate(repo string, patterns []utils.RegexPattern, hierarchy []utils.HierarchyLevel) {
+	utils.GlobalSnapshots.Activate(&utils.Snapshot{
+		Commit:    "test",
+		Path:      repo,
+		Patterns:  patterns,
+		Hierarchy: hierarchy,
+	})
+}
+
+func setup() {
+	activate("", testPatterns(), utils.DefaultHierarchy)
 }
 
 func teardown() {
-	utils.GlobalDomainPatternsMutex.Lock()
-	utils.GlobalDomainPatterns = nil
-	utils.GlobalDomainPatternsMutex.Unlock()
+	activate("", nil, utils.DefaultHierarchy)
 }
 
 func TestDetailsHandler(t *testing.T) {
@@ -66,9 +79,9 @@ func TestDetailsHandler(t *testing.T) {
 
 	t.Run("Invalid Regex", func(t *testing.T) {
 		// Add an invalid regex pattern
-		utils.GlobalDomainPatternsMutex.Lock()
-		utils.GlobalDomainPatterns[0].Regex = "(invalid"
-		utils.GlobalDomainPatternsMutex.Unlock()
+		patterns := testPatterns()
+		patterns[0].Regex = "(invalid"
+		activate("", patterns, utils.DefaultHierarchy)
 
 		req := httptest.NewRequest("GET", "/details/test", nil)
 		rr := httptest.NewRecorder()
@@ -80,9 +93,7 @@ func TestDetailsHandler(t *testing.T) {
 		assert.Equal(t, "Invalid Regex Pattern", strings.TrimSpace(string(body)))
 
 		// Revert to valid regex pattern
-		utils.GlobalDomainPatternsMutex.Lock()
-		utils.GlobalDomainPatterns[0].Regex = "(?P<Function>fn)-(?P<Datacenter>dc)"
-		utils.GlobalDomainPatternsMutex.Unlock()
+		setup()
 	})
 
 	t.Run("No Matching Pattern", func(t *testing.T) {
@@ -96,5 +107,181 @@ func TestDetailsHandler(t *testing.T) {
 		assert.Equal(t, "No matching pattern found", strings.TrimSpace(string(body)))
 	})
 
+	t.Run("Layers Are Deep Merged", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{
+			"all.yaml":            "function: {{ .Function }}\ncontact:\n  phone_number: 555-555-0000\n  support: support@example.com\n",
+			"functions/fn.yaml":   "contact:\n  security: security@example.com\n",
+			"datacenters/dc.yaml": "contact:\n  phone_number: 555-555-1234\n",
+		})
+		activate(repo, testPatterns(), utils.DefaultHierarchy)
+		defer setup()
+
+		req := httptest.NewRequest("GET", "/details/fn-dc", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, map[string]interface{}{
+			"function": "fn",
+			"contact": map[string]interface{}{
+				"phone_number": "555-555-1234",
+				"security":     "security@example.com",
+				"support":      "support@example.com",
+			},
+		}, got)
+	})
+
+	t.Run("Merge Directives", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{
+			"all.yaml":            "owner: superappteam\nports: [8100]\nntp_servers: [ntp2]\n",
+			"functions/fn.yaml":   "ports: !append [8101]\n",
+			"datacenters/dc.yaml": "ntp_servers: !prepend [ntp1]\n",
+			"devices/fn-dc.yaml":  "owner: ~delete\nports: !append [8102]\n",
+		})
+		activate(repo, testPatterns(), utils.DefaultHierarchy)
+		defer setup()
+
+		req := httptest.NewRequest("GET", "/details/fn-dc", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, map[string]interface{}{
+			"ports":       []interface{}{float64(8100), float64(8101), float64(8102)},
+			"ntp_servers": []interface{}{"ntp1", "ntp2"},
+		}, got)
+	})
+
+	t.Run("Configured Hierarchy", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{
+			"all.yaml":               "environment: none\nrole: none\n",
+			"environments/prod.yaml": "environment: {{ .Environment }}\n",
+			"roles/db.yaml":          "role: {{ .Role }}\n",
+		})
+
+		patterns := append(testPatterns(), utils.RegexPattern{
+			Name:  "TestPattern2",
+			Regex: "^(?P<Role>[a-z]+)\\.(?P<Environment>[a-z]+)$",
+		})
+		activate(repo, patterns, []utils.HierarchyLevel{
+			{Name: "common", Path: "all.yaml", Required: true},
+			{Name: "environment", Path: "environments/{{ .Environment }}.yaml"},
+			{Name: "role", Path: "roles/{{ .Role }}.yaml"},
+		})
+		defer setup()
+
+		req := httptest.NewRequest("GET", "/details/db.prod", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, map[string]interface{}{"environment": "prod", "role": "db"}, got)
+	})
+
+	t.Run("Output Formats", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{
+			"all.yaml": "function: {{ .Function }}\ncontact:\n  phone_number: 555-555-1234\nports: [8102]\n",
+		})
+		activate(repo, testPatterns(), utils.DefaultHierarchy)
+		defer setup()
+
+		tests := []struct {
+			name                string
+			url                 string
+			accept              string
+			expectedStatus      int
+			expectedContentType string
+			expectedBody        string
+		}{
+			{
+				name:                "yaml from accept header",
+				url:                 "/details/fn-dc",
+				accept:              "application/yaml",
+				expectedStatus:      http.StatusOK,
+				expectedContentType: "application/yaml",
+				expectedBody:        "contact:\n    phone_number: 555-555-1234\nfunction: fn\nports:\n    - 8102\n",
+			},
+			{
+				name:                "dotenv from parameter",
+				url:                 "/details/fn-dc?format=env",
+				accept:              "application/json",
+				expectedStatus:      http.StatusOK,
+				expectedContentType: "text/plain; charset=utf-8",
+				expectedBody:        "CONTACT_PHONE_NUMBER=555-555-1234\nFUNCTION=fn\nPORTS_0=8102\n",
+			},
+			{
+				name:                "toml",
+				url:                 "/details/fn-dc?format=toml",
+				expectedStatus:      http.StatusOK,
+				expectedContentType: "application/toml",
+				expectedBody:        "function = 'fn'\nports = [8102]\n\n[contact]\nphone_number = '555-555-1234'\n",
+			},
+			{
+				name:           "ini cannot represent lists",
+				url:            "/details/fn-dc?format=ini",
+				expectedStatus: http.StatusUnprocessableEntity,
+				expectedBody:   "Configuration cannot be represented as ini: ini cannot represent ports: lists are not supported\n",
+			},
+			{
+				name:           "unsupported format",
+				url:            "/details/fn-dc?format=xml",
+				expectedStatus: http.StatusBadRequest,
+				expectedBody:   "Unsupported format xml\n",
+			},
+			{
+				name:           "unsupported accept header",
+				url:            "/details/fn-dc",
+				accept:         "text/html",
+				expectedStatus: http.StatusNotAcceptable,
+				expectedBody:   "None of the accepted media types is supported\n",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req := httptest.NewRequest("GET", tt.url, nil)
+				if tt.accept != "" {
+					req.Header.Set("Accept", tt.accept)
+				}
+				rr := httptest.NewRecorder()
+
+				h.ServeHTTP(rr, req)
+
+				assert.Equal(t, tt.expectedStatus, rr.Code)
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+				if tt.expectedContentType != "" {
+					assert.Equal(t, tt.expectedContentType, rr.Header().Get("Content-Type"))
+				}
+			})
+		}
+	})
+
 	// Add more tests for matching patterns, template processing, etc.
 }
+
+// writeRepoFiles creates a config repository layout below root.
+func writeRepoFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
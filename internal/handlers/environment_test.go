@@ -0,0 +1,87 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironments(t *testing.T) {
+	defaultRepo, stagingRepo := t.TempDir(), t.TempDir()
+	writeRepoFiles(t, defaultRepo, map[string]string{"all.yaml": "environment: production\n"})
+	writeRepoFiles(t, stagingRepo, map[string]string{"all.yaml": "environment: staging\n"})
+
+	activate(defaultRepo, testPatterns(), utils.DefaultHierarchy)
+	defer teardown()
+
+	// Each environment has its own patterns
+	staging := &utils.SnapshotStore{}
+	staging.Activate(&utils.Snapshot{
+		Commit:    "staging",
+		Path:      stagingRepo,
+		Patterns:  []utils.RegexPattern{{Name: "Staging", Regex: "^(?P<Function>fn)-stg$"}},
+		Hierarchy: utils.DefaultHierarchy,
+	})
+	utils.GlobalEnvironments.Add("team/staging", staging)
+
+	mux := handlers.SetupHandlers(&utils.Settings{})
+
+	tests := []struct {
+		name           string
+		path           string
+		header         string
+		expectedStatus int
+		expectedEnv    string
+	}{
+		{name: "default environment", path: "/details/fn-dc", expectedStatus: http.StatusOK, expectedEnv: "production"},
+		{name: "path prefix", path: "/env/team/staging/details/fn-stg", expectedStatus: http.StatusOK, expectedEnv: "staging"},
+		{name: "header", path: "/details/fn-stg", header: "team/staging", expectedStatus: http.StatusOK, expectedEnv: "staging"},
+		{name: "patterns of the environment", path: "/env/team/staging/details/fn-dc", expectedStatus: http.StatusNotFound},
+		{name: "explain", path: "/env/team/staging/explain/fn-stg", expectedStatus: http.StatusOK},
+		{name: "unknown environment prefix", path: "/env/qa/details/fn-dc", expectedStatus: http.StatusNotFound},
+		{name: "unknown environment header", path: "/details/fn-dc", header: "qa", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(handlers.EnvironmentHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			mux.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, strings.TrimSpace(rr.Body.String()))
+			if tt.expectedEnv != "" {
+				var got map[string]interface{}
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+				assert.Equal(t, tt.expectedEnv, got["environment"])
+			}
+		})
+	}
+}
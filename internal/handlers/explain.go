@@ -0,0 +1,117 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type explainLayer struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// skippedLayer is a hierarchy level skipped for a missing capture group
+type skippedLayer struct {
+	Name    string `json:"name"`
+	Capture string `json:"capture"`
+}
+
+type explainResponse struct {
+	Hostname string                       `json:"hostname"`
+	Pattern  utils.RegexPattern           `json:"pattern"`
+	Captures map[string]string            `json:"captures"`
+	Layers   []explainLayer               `json:"layers"`
+	Skipped  []skippedLayer               `json:"skipped,omitempty"`
+	Keys     map[string]*utils.Provenance `json:"keys"`
+}
+
+// ExplainHandler reports, for every leaf key of a host's configuration,
+// which layer file supplied the final value and which values it overrode.
+func ExplainHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Fetch hostname from the URL path
+		hostname := strings.TrimPrefix(r.URL.Path, "/explain/")
+		if hostname == "" {
+			http.Error(w, "Missing hostname", http.StatusBadRequest)
+			return
+		}
+		redact, ok := redactRequested(w, r)
+		if !ok {
+			return
+		}
+		identities, ok := clientIdentities(w, r)
+		if !ok {
+			return
+		}
+
+		snapshot, ok := acquireSnapshot(w, r)
+		if !ok {
+			return
+		}
+		defer snapshot.Release()
+
+		// Match the hostname against the domain patterns of the snapshot
+		match, err := utils.MatchHostname(hostname, snapshot.Patterns)
+		if err != nil {
+			http.Error(w, "Invalid Regex Pattern", http.StatusInternalServerError)
+			return
+		}
+		if match == nil {
+			http.Error(w, "No matching pattern found", http.StatusNotFound)
+			return
+		}
+
+		layers, err := utils.ResolveLayers(snapshot, hostname, match.Captures)
+		if err != nil {
+			writeLayerError(w, err)
+			return
+		}
+
+		response := explainResponse{
+			Hostname: hostname,
+			Pattern:  match.Pattern,
+			Captures: match.Captures,
+			Layers:   make([]explainLayer, 0, len(layers)),
+			Keys:     utils.ExplainLayers(layers),
+		}
+		for _, layer := range layers {
+			response.Layers = append(response.Layers, explainLayer{Name: layer.Name, File: layer.Path})
+		}
+		for _, missing := range utils.SkippedLevels(snapshot, hostname, match.Captures) {
+			response.Skipped = append(response.Skipped, skippedLayer{Name: missing.Level, Capture: missing.Capture})
+		}
+		snapshot.Sensitive.MaskProvenance(response.Keys, identities)
+		if redact {
+			redactProvenance(response.Keys)
+		}
+
+		jsonData, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, "Failed to convert to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+	}
+}
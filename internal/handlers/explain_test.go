@@ -0,0 +1,94 @@
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainHandler(t *testing.T) {
+	setup()
+	defer teardown()
+
+	h := http.HandlerFunc(handlers.ExplainHandler())
+
+	t.Run("Missing Hostname", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/explain/", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("No Matching Pattern", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/explain/test", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("Key Provenance", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{
+			"all.yaml":            "owner: superappteam\ncontact:\n  phone_number: 555-555-0000\n",
+			"datacenters/dc.yaml": "contact:\n  phone_number: 555-555-1234\n",
+		})
+		activate(repo, testPatterns(), utils.DefaultHierarchy)
+		defer setup()
+
+		req := httptest.NewRequest("GET", "/explain/fn-dc", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, "fn-dc", got["hostname"])
+		assert.Equal(t, map[string]interface{}{"name": "TestPattern1", "regex": "(?P<Function>fn)-(?P<Datacenter>dc)"}, got["pattern"])
+		assert.Equal(t, map[string]interface{}{"Function": "fn", "Datacenter": "dc"}, got["captures"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"name": "common", "file": "all.yaml"},
+			map[string]interface{}{"name": "datacenter", "file": "datacenters/dc.yaml"},
+		}, got["layers"])
+		assert.Equal(t, map[string]interface{}{
+			"owner": map[string]interface{}{"value": "superappteam", "layer": "common", "file": "all.yaml"},
+			"contact.phone_number": map[string]interface{}{
+				"value": "555-555-1234",
+				"layer": "datacenter",
+				"file":  "datacenters/dc.yaml",
+				"overrides": []interface{}{
+					map[string]interface{}{"value": "555-555-0000", "layer": "common", "file": "all.yaml"},
+				},
+			},
+		}, got["keys"])
+		assert.NotContains(t, got, "skipped")
+	})
+
+	t.Run("Skipped Layers", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{"all.yaml": "owner: superappteam\n"})
+		hierarchy := append([]utils.HierarchyLevel{}, utils.DefaultHierarchy...)
+		hierarchy = append(hierarchy, utils.HierarchyLevel{Name: "rack", Path: "racks/{{ .Rack }}.yaml"})
+		activate(repo, testPatterns(), hierarchy)
+		defer setup()
+
+		req := httptest.NewRequest("GET", "/explain/fn-dc", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, []interface{}{map[string]interface{}{"name": "rack", "capture": "Rack"}}, got["skipped"])
+	})
+}
@@ -0,0 +1,67 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// writeConfig encodes data in the format requested by the ?format=
+// parameter or, without it, the Accept header.
+func writeConfig(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
+	format, ok := requestedFormat(w, r)
+	if !ok {
+		return
+	}
+
+	output, err := format.Encode(data)
+	if err != nil {
+		var formatErr *utils.FormatError
+		if errors.As(err, &formatErr) {
+			http.Error(w, "Configuration cannot be represented as "+format.Name+": "+formatErr.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Error().Err(err).Str("Format", format.Name).Msg("Failed to encode configuration")
+		http.Error(w, "Failed to convert to "+format.Name, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Add("Vary", "Accept")
+	w.Write(output)
+}
+
+func requestedFormat(w http.ResponseWriter, r *http.Request) (utils.OutputFormat, bool) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		format, ok := utils.FormatByName(name)
+		if !ok {
+			http.Error(w, "Unsupported format "+name, http.StatusBadRequest)
+		}
+		return format, ok
+	}
+
+	format, ok := utils.NegotiateFormat(r.Header.Get("Accept"))
+	if !ok {
+		http.Error(w, "None of the accepted media types is supported", http.StatusNotAcceptable)
+	}
+	return format, ok
+}
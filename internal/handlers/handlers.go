@@ -19,15 +19,81 @@ Copyright (C) 2023 Operistech Inc.
 package handlers
 
 import (
+	"configNexus/internal/utils"
+	"context"
 	"net/http"
+	"strings"
 )
 
+// EnvironmentHeader selects the branch a request is served from when the
+// path has no /env/<branch>/ prefix.
+const EnvironmentHeader = "X-ConfigNexus-Environment"
+
+type environmentKey struct{}
+
 // SetupHandlers sets up HTTP handlers for the application and returns the mux.
-func SetupHandlers() *http.ServeMux {
+func SetupHandlers(settings *utils.Settings) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Welcome to ConfigNexus!"))
 	})
-	mux.Handle("/details/", DetailsHandler())
+	policy := NewAccessPolicy(settings)
+	mux.Handle("/details/", policy.HostScoped("/details/", DetailsHandler()))
+	mux.Handle("/explain/", policy.HostScoped("/explain/", ExplainHandler()))
+	mux.Handle("/hosts", policy.AdminOnly(HostsHandler()))
+	mux.Handle("/search", policy.AdminOnly(SearchHandler()))
+	mux.Handle("/inventory/ansible", policy.AdminOnly(AnsibleInventoryHandler()))
+	mux.Handle("/enc/puppet/", policy.HostScoped("/enc/puppet/", PuppetENCHandler()))
+	mux.Handle("/status", policy.AdminOnly(StatusHandler(utils.WatchedRepoStatus)))
+	mux.Handle("/env/", EnvironmentHandler(&utils.GlobalEnvironments, mux))
+	if ca := utils.GlobalCertificateAuthority; ca != nil {
+		mux.Handle("/pki/ca.pem", CACertificateHandler(ca))
+		mux.Handle("/pki/crl", CRLHandler(ca))
+		mux.Handle("/pki/sign", SignHandler(ca, settings.CAAutosign, settings.AdminClients))
+	}
+	// Without a secret anybody could make us hammer the git server
+	if settings.WebhookSecret != "" {
+		mux.Handle("/hooks/git", WebhookHandler(settings.WebhookSecret, utils.TriggerRefresh))
+	}
 	return mux
 }
+
+// EnvironmentHandler serves /env/<branch>/<path> as <path> from the
+// snapshots of branch.
+func EnvironmentHandler(environments *utils.Environments, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		branch, rest, ok := environments.Split(strings.TrimPrefix(r.URL.Path, "/env/"))
+		if !ok || strings.HasPrefix(rest, "env/") {
+			http.Error(w, "Unknown environment", http.StatusNotFound)
+			return
+		}
+
+		envRequest := r.Clone(context.WithValue(r.Context(), environmentKey{}, branch))
+		envRequest.URL.Path = "/" + rest
+		envRequest.URL.RawPath = ""
+		next.ServeHTTP(w, envRequest)
+	}
+}
+
+// acquireSnapshot returns the active snapshot of the environment the request
+// selected, so every file of the request is read from the same commit even
+// if a newer one is activated meanwhile. The caller must release it once the
+// request is done with its files.
+func acquireSnapshot(w http.ResponseWriter, r *http.Request) (*utils.Snapshot, bool) {
+	branch, ok := r.Context().Value(environmentKey{}).(string)
+	if !ok {
+		branch = r.Header.Get(EnvironmentHeader)
+	}
+	store := utils.GlobalEnvironments.Store(branch)
+	if store == nil {
+		http.Error(w, "Unknown environment", http.StatusNotFound)
+		return nil, false
+	}
+
+	snapshot := store.Acquire()
+	if snapshot == nil {
+		http.Error(w, "Configuration not loaded", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return snapshot, true
+}
@@ -19,6 +19,7 @@ Copyright (C) 2023 Operistech Inc.
 package handlers
 
 import (
+	"configNexus/internal/utils"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -26,7 +27,7 @@ import (
 )
 
 func TestSetupHandlers(t *testing.T) {
-	mux := SetupHandlers()
+	mux := SetupHandlers(&utils.Settings{WebhookSecret: "secret"})
 
 	t.Run("root endpoint returns welcome message", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/", nil)
@@ -56,4 +57,105 @@ func TestSetupHandlers(t *testing.T) {
 			t.Errorf("Expected /details/ to be handled; got status %v", resp.Status)
 		}
 	})
+
+	t.Run("/explain/ endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/explain/", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode == http.StatusNotFound {
+			t.Errorf("Expected /explain/ to be handled; got status %v", resp.Status)
+		}
+	})
+
+	t.Run("/env/ endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/env/", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != "Unknown environment\n" {
+			t.Errorf("Expected /env/ to be handled; got body '%s'", body)
+		}
+	})
+
+	t.Run("/inventory/ansible endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/inventory/ansible", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) == "Welcome to ConfigNexus!" {
+			t.Errorf("Expected /inventory/ansible to be handled; got status %v", resp.Status)
+		}
+	})
+
+	t.Run("/enc/puppet/ endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/enc/puppet/", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected /enc/puppet/ to be handled; got status %v", resp.Status)
+		}
+	})
+
+	t.Run("/hosts endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/hosts?orphan=maybe", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected /hosts to be handled; got status %v", resp.Status)
+		}
+	})
+
+	t.Run("/search endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/search", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected /search to be handled; got status %v", resp.Status)
+		}
+	})
+
+	t.Run("/hooks/git endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/hooks/git", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode == http.StatusNotFound {
+			t.Errorf("Expected /hooks/git to be handled; got status %v", resp.Status)
+		}
+	})
+
+	t.Run("/hooks/git endpoint needs a secret", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/hooks/git", nil)
+		w := httptest.NewRecorder()
+		SetupHandlers(&utils.Settings{}).ServeHTTP(w, req)
+
+		// Falls through to the welcome page
+		body, _ := ioutil.ReadAll(w.Result().Body)
+		if string(body) != "Welcome to ConfigNexus!" {
+			t.Errorf("Expected /hooks/git to be disabled; got body '%s'", body)
+		}
+	})
+
+	t.Run("/status endpoint is handled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/status", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if ct := w.Result().Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected /status to return JSON; got %v", ct)
+		}
+	})
 }
@@ -0,0 +1,80 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strconv"
+)
+
+// HostsHandler lists the known hosts of the active commit with the pattern
+// they match. Query parameters filter on capture group values, e.g.
+// ?Function=postgresql&Datacenter=slc, and ?orphan=true|false on whether a
+// host matches no pattern.
+func HostsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filters := r.URL.Query()
+		orphan, filterOrphans := filters["orphan"]
+		delete(filters, "orphan")
+
+		wantOrphans := false
+		if filterOrphans {
+			var err error
+			if wantOrphans, err = strconv.ParseBool(orphan[0]); err != nil {
+				http.Error(w, "Invalid orphan filter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		snapshot, ok := acquireSnapshot(w, r)
+		if !ok {
+			return
+		}
+		defer snapshot.Release()
+
+		entries, err := utils.ListHosts(snapshot)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to list hosts")
+			http.Error(w, "Failed to list hosts", http.StatusInternalServerError)
+			return
+		}
+
+		hosts := make([]utils.HostEntry, 0, len(entries))
+		for _, entry := range entries {
+			if filterOrphans && entry.Orphan != wantOrphans {
+				continue
+			}
+			if entry.MatchesCaptures(filters) {
+				hosts = append(hosts, entry)
+			}
+		}
+
+		jsonData, err := json.Marshal(hosts)
+		if err != nil {
+			http.Error(w, "Failed to convert to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+	}
+}
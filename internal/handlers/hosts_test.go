@@ -0,0 +1,92 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostsHandler(t *testing.T) {
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"devices/fn-dc.yaml": "a: 1\n",
+		"hosts.yaml":         "hosts: [fn-dc2, printer]\n",
+	})
+	activate(repo, []utils.RegexPattern{{Name: "TestPattern", Regex: "^(?P<Function>fn)-(?P<Datacenter>dc[0-9]*)$"}}, utils.DefaultHierarchy)
+	defer teardown()
+
+	h := http.HandlerFunc(handlers.HostsHandler())
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedHosts  []string
+	}{
+		{name: "all hosts", url: "/hosts", expectedStatus: http.StatusOK, expectedHosts: []string{"fn-dc", "fn-dc2", "printer"}},
+		{name: "capture filter", url: "/hosts?Function=fn&Datacenter=dc2", expectedStatus: http.StatusOK, expectedHosts: []string{"fn-dc2"}},
+		{name: "repeated capture filter", url: "/hosts?Datacenter=dc&Datacenter=dc2", expectedStatus: http.StatusOK, expectedHosts: []string{"fn-dc", "fn-dc2"}},
+		{name: "no match", url: "/hosts?Function=db", expectedStatus: http.StatusOK, expectedHosts: []string{}},
+		{name: "orphans", url: "/hosts?orphan=true", expectedStatus: http.StatusOK, expectedHosts: []string{"printer"}},
+		{name: "matched hosts", url: "/hosts?orphan=false", expectedStatus: http.StatusOK, expectedHosts: []string{"fn-dc", "fn-dc2"}},
+		{name: "invalid orphan filter", url: "/hosts?orphan=maybe", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var got []utils.HostEntry
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			hosts := []string{}
+			for _, entry := range got {
+				hosts = append(hosts, entry.Hostname)
+			}
+			assert.Equal(t, tt.expectedHosts, hosts)
+		})
+	}
+
+	t.Run("entry", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/hosts?Datacenter=dc", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.JSONEq(t, `[{
+			"hostname": "fn-dc",
+			"pattern": {"name": "TestPattern", "regex": "^(?P<Function>fn)-(?P<Datacenter>dc[0-9]*)$"},
+			"captures": {"Function": "fn", "Datacenter": "dc"},
+			"orphan": false
+		}]`, rr.Body.String())
+	})
+}
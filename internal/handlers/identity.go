@@ -0,0 +1,62 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"net/http"
+	"strings"
+)
+
+// clientIdentities returns the identities of the client: the names of its
+// verified certificate and the identity behind the bearer token of the
+// request, none for anonymous clients. An unknown token is reported to the
+// client rather than served as anonymous.
+func clientIdentities(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	identities := certificateNames(r)
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return identities, true
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		http.Error(w, "Unsupported authorization", http.StatusUnauthorized)
+		return nil, false
+	}
+	identity, ok := utils.GlobalClientTokens.Identify(strings.TrimSpace(token))
+	if !ok {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return append(identities, identity), true
+}
+
+// certificateNames returns the common name and DNS names of the verified
+// client certificate, none without mutual TLS.
+func certificateNames(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return append(names, cert.DNSNames...)
+}
@@ -0,0 +1,122 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSensitiveKeys(t *testing.T) {
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"all.yaml":       "user: app\ndb_password: s3cr3t\n",
+		"hosts.yaml":     "hosts: [fn-dc]\n",
+		"sensitive.yaml": "sensitive:\n  - path: \"*_password\"\n    readers: [dba]\n",
+	})
+	rules, err := utils.ReadSensitiveRules(filepath.Join(repo, "sensitive.yaml"))
+	assert.NoError(t, err)
+	utils.GlobalSnapshots.Activate(&utils.Snapshot{
+		Commit:    "test",
+		Path:      repo,
+		Patterns:  testPatterns(),
+		Hierarchy: utils.DefaultHierarchy,
+		Sensitive: rules,
+	})
+
+	tokensFile := filepath.Join(t.TempDir(), "clients.yaml")
+	assert.NoError(t, os.WriteFile(tokensFile, []byte("clients:\n  dba: dba-t0ken\n  web: web-t0ken\n"), 0o600))
+	utils.GlobalClientTokens, err = utils.LoadClientTokens(tokensFile)
+	assert.NoError(t, err)
+	defer func() {
+		utils.GlobalClientTokens = nil
+		setup()
+	}()
+
+	details := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/details/fn-dc", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handlers.DetailsHandler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for token, expected := range map[string]string{"": "[REDACTED]", "web-t0ken": "[REDACTED]", "dba-t0ken": "s3cr3t"} {
+		rr := details(token)
+		assert.Equal(t, http.StatusOK, rr.Code, token)
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, map[string]interface{}{"user": "app", "db_password": expected}, got, token)
+	}
+
+	t.Run("invalid token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, details("guessed").Code)
+
+		req := httptest.NewRequest("GET", "/details/fn-dc", nil)
+		req.SetBasicAuth("dba", "dba-t0ken")
+		rr := httptest.NewRecorder()
+		handlers.DetailsHandler().ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("certificate names", func(t *testing.T) {
+		byCertificate := func(commonName string, dnsNames ...string) map[string]interface{} {
+			rr := httptest.NewRecorder()
+			handlers.DetailsHandler().ServeHTTP(rr, withCertificate(httptest.NewRequest("GET", "/details/fn-dc", nil), commonName, dnsNames...))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var got map[string]interface{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			return got
+		}
+		assert.Equal(t, "s3cr3t", byCertificate("dba")["db_password"])
+		assert.Equal(t, "s3cr3t", byCertificate("fn-dc", "dba")["db_password"], "only a DNS name is a reader")
+		assert.Equal(t, "[REDACTED]", byCertificate("fn-dc", "web")["db_password"])
+	})
+
+	t.Run("explain", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handlers.ExplainHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/explain/fn-dc", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "s3cr3t")
+	})
+
+	t.Run("search can not guess values", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handlers.SearchHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/search?q=db_password%3Ds3cr3t", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"commit": "test", "hosts": []}`, rr.Body.String())
+	})
+
+	t.Run("inventory", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handlers.AnsibleInventoryHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/inventory/ansible", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "s3cr3t")
+	})
+}
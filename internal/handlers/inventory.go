@@ -0,0 +1,63 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// AnsibleInventoryHandler returns the known hosts in the JSON format of an
+// Ansible dynamic inventory script. Hosts that fail to render are logged and
+// left out, so a single broken host does not block the rest of the fleet.
+func AnsibleInventoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identities, ok := clientIdentities(w, r)
+		if !ok {
+			return
+		}
+
+		snapshot, ok := acquireSnapshot(w, r)
+		if !ok {
+			return
+		}
+		defer snapshot.Release()
+
+		inventory, failures, err := utils.AnsibleInventory(snapshot, identities)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to build Ansible inventory")
+			http.Error(w, "Failed to build inventory", http.StatusInternalServerError)
+			return
+		}
+		for hostname, failure := range failures {
+			log.Warn().Str("Hostname", hostname).Str("Error", failure).Msg("Left host out of Ansible inventory")
+		}
+
+		jsonData, err := json.Marshal(inventory)
+		if err != nil {
+			http.Error(w, "Failed to convert to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnsibleInventoryHandler(t *testing.T) {
+	h := http.HandlerFunc(handlers.AnsibleInventoryHandler())
+
+	t.Run("Inventory", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{
+			"all.yaml":           "function: {{ .Function }}\n",
+			"devices/fn-dc.yaml": "owner: team\n",
+			"hosts.yaml":         "hosts: [printer]\n",
+		})
+		activate(repo, testPatterns(), utils.DefaultHierarchy)
+		defer teardown()
+
+		req := httptest.NewRequest("GET", "/inventory/ansible", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, map[string]interface{}{
+			"_meta": map[string]interface{}{
+				"hostvars": map[string]interface{}{
+					"fn-dc": map[string]interface{}{"function": "fn", "owner": "team"},
+				},
+			},
+			"all":           map[string]interface{}{"children": []interface{}{"datacenter_dc", "function_fn", "ungrouped"}},
+			"datacenter_dc": map[string]interface{}{"hosts": []interface{}{"fn-dc"}},
+			"function_fn":   map[string]interface{}{"hosts": []interface{}{"fn-dc"}},
+			"ungrouped":     map[string]interface{}{"hosts": []interface{}{"printer"}},
+		}, got)
+	})
+
+	t.Run("Broken Host File", func(t *testing.T) {
+		repo := t.TempDir()
+		writeRepoFiles(t, repo, map[string]string{
+			"all.yaml":           "a: 1\n",
+			"devices/fn-dc.yaml": "owner: {{ .Function\n",
+			"hosts.yaml":         "hosts: [printer]\n",
+		})
+		activate(repo, testPatterns(), utils.DefaultHierarchy)
+		defer teardown()
+
+		req := httptest.NewRequest("GET", "/inventory/ansible", nil)
+		rr := httptest.NewRecorder()
+
+		h.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var got map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, map[string]interface{}{
+			"_meta":     map[string]interface{}{"hostvars": map[string]interface{}{}},
+			"all":       map[string]interface{}{"children": []interface{}{"ungrouped"}},
+			"ungrouped": map[string]interface{}{"hosts": []interface{}{"printer"}},
+		}, got)
+	})
+}
@@ -0,0 +1,157 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxCSRSize limits the size of certificate requests we accept
+const maxCSRSize = 64 << 10
+
+// CACertificateHandler publishes the certificate of the internal CA.
+func CACertificateHandler(ca *utils.CertificateAuthority) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(ca.CertificatePEM())
+	}
+}
+
+// CRLHandler publishes the DER encoded revocation list of the internal CA.
+func CRLHandler(ca *utils.CertificateAuthority) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		crl, err := ca.CRL()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create CRL")
+			http.Error(w, "Failed to create CRL", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(crl)
+	}
+}
+
+// SignHandler issues a client certificate for the PEM CSR posted by a host.
+// The hostname must match a domain pattern, and the host must present a
+// certificate for the same name (renewal), a bootstrap token as bearer
+// token, or match one of the autosign globs. Admin names are never
+// autosigned, they need a bootstrap token.
+func SignHandler(ca *utils.CertificateAuthority, autosign, admins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSRSize))
+		if err != nil {
+			http.Error(w, "Failed to read certificate request", http.StatusBadRequest)
+			return
+		}
+		csr, hostname, err := utils.ParseCertificateRequest(body)
+		if err != nil {
+			http.Error(w, "Invalid certificate request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		snapshot, ok := acquireSnapshot(w, r)
+		if !ok {
+			return
+		}
+		match, err := utils.MatchHostname(hostname, snapshot.Patterns)
+		snapshot.Release()
+		if err != nil {
+			http.Error(w, "Invalid pattern: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if match == nil {
+			http.Error(w, "No matching pattern found for "+hostname, http.StatusForbidden)
+			return
+		}
+
+		method, token, ok := enrollment(w, r, autosign, admins, hostname)
+		if !ok {
+			return
+		}
+
+		var cert *x509.Certificate
+		if token != "" {
+			var valid bool
+			cert, valid, err = ca.SignWithBootstrapToken(csr, hostname, token)
+			if err == nil && !valid {
+				log.Warn().Str("Hostname", hostname).Str("Remote", r.RemoteAddr).Msg("Rejected invalid bootstrap token")
+				http.Error(w, "Invalid bootstrap token", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			cert, err = ca.Sign(csr, hostname)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("Hostname", hostname).Msg("Failed to sign certificate")
+			http.Error(w, "Failed to sign certificate", http.StatusInternalServerError)
+			return
+		}
+		log.Info().
+			Str("Hostname", hostname).
+			Str("Serial", cert.SerialNumber.Text(16)).
+			Str("Method", method).
+			Time("NotAfter", cert.NotAfter).
+			Msg("Issued client certificate")
+
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+}
+
+// enrollment returns how the client is allowed to get a certificate for
+// hostname and the bootstrap token it sent, which still has to be checked
+// when the certificate is signed. Refusals are reported to the client.
+func enrollment(w http.ResponseWriter, r *http.Request, autosign, admins []string, hostname string) (string, string, bool) {
+	for _, name := range certificateNames(r) {
+		if strings.EqualFold(name, hostname) {
+			return "renewal", "", true
+		}
+	}
+
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok || strings.TrimSpace(token) == "" {
+			http.Error(w, "Unsupported authorization", http.StatusUnauthorized)
+			return "", "", false
+		}
+		return "bootstrap token", strings.TrimSpace(token), true
+	}
+
+	if utils.MatchesAny(autosign, hostname) {
+		if utils.MatchesAny(admins, hostname) {
+			log.Warn().Str("Hostname", hostname).Str("Remote", r.RemoteAddr).Msg("Refused to autosign an admin name")
+			http.Error(w, "Admin names need a bootstrap token", http.StatusForbidden)
+			return "", "", false
+		}
+		return "autosign", "", true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return "", "", false
+}
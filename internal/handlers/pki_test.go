@@ -0,0 +1,133 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"bytes"
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// csrFor returns a PEM certificate request for hostname.
+func csrFor(t *testing.T, hostname string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: hostname}}, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate request: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestPKIHandlers(t *testing.T) {
+	setup()
+	defer teardown()
+
+	dir := t.TempDir()
+	ca, err := utils.LoadCertificateAuthority(dir, time.Hour)
+	assert.NoError(t, err)
+	utils.GlobalCertificateAuthority = ca
+	defer func() { utils.GlobalCertificateAuthority = nil }()
+
+	mux := handlers.SetupHandlers(&utils.Settings{CAAutosign: []string{"auto-*"}, AdminClients: []string{"auto-admin-*"}})
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	sign := func(hostname, token string) *http.Request {
+		req := httptest.NewRequest("POST", "/pki/sign", bytes.NewReader(csrFor(t, hostname)))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	t.Run("publishes the CA certificate", func(t *testing.T) {
+		rr := serve(httptest.NewRequest("GET", "/pki/ca.pem", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, ca.CertificatePEM(), rr.Body.Bytes())
+	})
+
+	t.Run("publishes the CRL", func(t *testing.T) {
+		rr := serve(httptest.NewRequest("GET", "/pki/crl", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/pkix-crl", rr.Header().Get("Content-Type"))
+		crl, err := x509.ParseRevocationList(rr.Body.Bytes())
+		assert.NoError(t, err)
+		assert.NoError(t, crl.CheckSignatureFrom(ca.Cert))
+	})
+
+	t.Run("bootstrap token", func(t *testing.T) {
+		token, err := utils.CreateBootstrapToken(dir, "fn-*", time.Hour)
+		assert.NoError(t, err)
+
+		rr := serve(sign("fn-dc", token))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		block, _ := pem.Decode(rr.Body.Bytes())
+		if assert.NotNil(t, block) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			assert.NoError(t, err)
+			assert.Equal(t, "fn-dc", cert.Subject.CommonName)
+			assert.NoError(t, cert.CheckSignatureFrom(ca.Cert))
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, serve(sign("fn-dc", token)).Code, "the token was used")
+	})
+
+	t.Run("renewal", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(withCertificate(sign("fn-dc", ""), "fn-dc")).Code)
+		assert.Equal(t, http.StatusForbidden, serve(withCertificate(sign("fn-dc", ""), "fn-xx")).Code)
+	})
+
+	t.Run("autosign", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(sign("auto-fn-dc", "")).Code)
+	})
+
+	t.Run("admin names are not autosigned", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(sign("auto-admin-fn-dc", "")).Code)
+
+		token, err := utils.CreateBootstrapToken(dir, "auto-admin-*", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, serve(sign("auto-admin-fn-dc", token)).Code)
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(sign("fn-dc", "")).Code, "without authorization")
+		assert.Equal(t, http.StatusForbidden, serve(sign("auto-web1", "")).Code, "hostname without pattern")
+		assert.Equal(t, http.StatusUnauthorized, serve(sign("fn-dc", "guessed")).Code)
+		assert.Equal(t, http.StatusBadRequest, serve(httptest.NewRequest("POST", "/pki/sign", bytes.NewReader([]byte("fn-dc")))).Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(httptest.NewRequest("GET", "/pki/sign", nil)).Code)
+	})
+}
@@ -0,0 +1,87 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"net/http"
+	"strings"
+)
+
+// AccessPolicy restricts the hosts a client may fetch. With SelfOnly, a
+// client may only fetch the host its certificate is issued to, and only
+// clients matching one of the Admins globs may fetch any host or list the
+// fleet. A nil policy lets every client fetch everything.
+type AccessPolicy struct {
+	SelfOnly bool
+	Admins   []string
+}
+
+// NewAccessPolicy returns the policy configured in the settings.
+func NewAccessPolicy(settings *utils.Settings) *AccessPolicy {
+	return &AccessPolicy{
+		SelfOnly: settings.DetailsPolicy == utils.DetailsPolicySelfOnly,
+		Admins:   settings.AdminClients,
+	}
+}
+
+// HostScoped guards a handler serving the host named by the path after
+// prefix, e.g. /details/<hostname>.
+func (p *AccessPolicy) HostScoped(prefix string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hostname := strings.TrimPrefix(r.URL.Path, prefix)
+		if p.authorize(w, r, hostname) {
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// AdminOnly guards a handler serving information about every host.
+func (p *AccessPolicy) AdminOnly(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p.authorize(w, r, "") {
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// authorize reports whether the client may fetch hostname, an empty
+// hostname needs an admin. Refusals are reported to the client.
+func (p *AccessPolicy) authorize(w http.ResponseWriter, r *http.Request, hostname string) bool {
+	if p == nil || !p.SelfOnly {
+		return true
+	}
+
+	names, ok := clientIdentities(w, r)
+	if !ok {
+		return false
+	}
+	if len(names) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return false
+	}
+
+	for _, name := range names {
+		if (hostname != "" && strings.EqualFold(name, hostname)) || utils.MatchesAny(p.Admins, name) {
+			return true
+		}
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
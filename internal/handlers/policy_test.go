@@ -0,0 +1,107 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withCertificate makes req look like it arrived with a verified client
+// certificate for commonName and dnsNames.
+func withCertificate(req *http.Request, commonName string, dnsNames ...string) *http.Request {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}, DNSNames: dnsNames}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return req
+}
+
+func TestAccessPolicy(t *testing.T) {
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{"all.yaml": "a: 1\n", "hosts.yaml": "hosts: [fn-dc]\n"})
+	activate(repo, testPatterns(), utils.DefaultHierarchy)
+	defer setup()
+
+	mux := handlers.SetupHandlers(&utils.Settings{
+		DetailsPolicy: utils.DetailsPolicySelfOnly,
+		AdminClients:  []string{"puppet.example.com", "ops"},
+	})
+	serve := func(req *http.Request) int {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name     string
+		request  *http.Request
+		expected int
+	}{
+		{"own details", withCertificate(httptest.NewRequest("GET", "/details/fn-dc", nil), "fn-dc"), http.StatusOK},
+		{"own details by DNS name", withCertificate(httptest.NewRequest("GET", "/details/fn-dc", nil), "", "FN-DC"), http.StatusOK},
+		{"own details of an environment", withCertificate(httptest.NewRequest("GET", "/env/main/details/fn-dc", nil), "fn-dc"), http.StatusOK},
+		{"other details", withCertificate(httptest.NewRequest("GET", "/details/fn-dc", nil), "fn-xx"), http.StatusForbidden},
+		{"other explain", withCertificate(httptest.NewRequest("GET", "/explain/fn-dc", nil), "fn-xx"), http.StatusForbidden},
+		{"without certificate", httptest.NewRequest("GET", "/details/fn-dc", nil), http.StatusUnauthorized},
+		{"admin details", withCertificate(httptest.NewRequest("GET", "/details/fn-dc", nil), "puppet.example.com"), http.StatusOK},
+		{"admin puppet", withCertificate(httptest.NewRequest("GET", "/enc/puppet/fn-dc", nil), "puppet.example.com"), http.StatusOK},
+		{"admin inventory", withCertificate(httptest.NewRequest("GET", "/inventory/ansible", nil), "puppet.example.com"), http.StatusOK},
+		{"host inventory", withCertificate(httptest.NewRequest("GET", "/inventory/ansible", nil), "fn-dc"), http.StatusForbidden},
+		{"host search", withCertificate(httptest.NewRequest("GET", "/search?q=a", nil), "fn-dc"), http.StatusForbidden},
+		{"host listing", withCertificate(httptest.NewRequest("GET", "/hosts", nil), "fn-dc"), http.StatusForbidden},
+		{"admin status", withCertificate(httptest.NewRequest("GET", "/status", nil), "puppet.example.com"), http.StatusOK},
+		{"host status", withCertificate(httptest.NewRequest("GET", "/status", nil), "fn-dc"), http.StatusForbidden},
+	}
+	utils.GlobalEnvironments.Default = "main"
+	defer func() { utils.GlobalEnvironments.Default = "" }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, serve(tt.request))
+		})
+	}
+
+	t.Run("admin by token", func(t *testing.T) {
+		tokensFile := filepath.Join(t.TempDir(), "clients.yaml")
+		assert.NoError(t, os.WriteFile(tokensFile, []byte("clients:\n  ops: 0ps-t0ken\n"), 0o600))
+		tokens, err := utils.LoadClientTokens(tokensFile)
+		assert.NoError(t, err)
+		utils.GlobalClientTokens = tokens
+		defer func() { utils.GlobalClientTokens = nil }()
+
+		req := httptest.NewRequest("GET", "/details/fn-dc", nil)
+		req.Header.Set("Authorization", "Bearer 0ps-t0ken")
+		assert.Equal(t, http.StatusOK, serve(req))
+	})
+
+	t.Run("open policy", func(t *testing.T) {
+		open := handlers.SetupHandlers(&utils.Settings{DetailsPolicy: utils.DetailsPolicyOpen})
+		rr := httptest.NewRecorder()
+		open.ServeHTTP(rr, httptest.NewRequest("GET", "/details/fn-dc", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
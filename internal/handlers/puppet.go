@@ -0,0 +1,70 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"strings"
+)
+
+// PuppetENCHandler classifies a Puppet node by its certname, using the
+// merged configuration of the host and the mapping in puppet.yaml.
+func PuppetENCHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Fetch certname from the URL path
+		certname := strings.TrimPrefix(r.URL.Path, "/enc/puppet/")
+		if certname == "" {
+			http.Error(w, "Missing certname", http.StatusBadRequest)
+			return
+		}
+		identities, ok := clientIdentities(w, r)
+		if !ok {
+			return
+		}
+
+		snapshot, ok := acquireSnapshot(w, r)
+		if !ok {
+			return
+		}
+		defer snapshot.Release()
+
+		data, ok := renderHost(w, snapshot, certname, identities)
+		if !ok {
+			return
+		}
+
+		node, err := snapshot.Puppet.Classify(data)
+		if err != nil {
+			log.Error().Err(err).Str("Certname", certname).Msg("Failed to classify Puppet node")
+			http.Error(w, "Failed to classify node: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		yamlData, err := yaml.Marshal(node)
+		if err != nil {
+			http.Error(w, "Failed to convert to YAML", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(yamlData)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPuppetENCHandler(t *testing.T) {
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"all.yaml":          "owner: superappteam\npuppet:\n  environment: production\n",
+		"functions/fn.yaml": "puppet:\n  classes: [ntp, \"role::{{ .Function }}\"]\n",
+	})
+	utils.GlobalSnapshots.Activate(&utils.Snapshot{
+		Commit:    "test",
+		Path:      repo,
+		Patterns:  testPatterns(),
+		Hierarchy: utils.DefaultHierarchy,
+		Puppet:    utils.PuppetMapping{Classes: "puppet.classes", Environment: "puppet.environment"},
+	})
+	defer teardown()
+
+	h := http.HandlerFunc(handlers.PuppetENCHandler())
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "classified node",
+			path:           "/enc/puppet/fn-dc",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "classes:\n    - ntp\n    - role::fn\nparameters:\n    owner: superappteam\nenvironment: production\n",
+		},
+		{
+			name:           "missing certname",
+			path:           "/enc/puppet/",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Missing certname\n",
+		},
+		{
+			name:           "unknown certname",
+			path:           "/enc/puppet/unknown",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "No matching pattern found\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
@@ -0,0 +1,51 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"net/http"
+	"strconv"
+)
+
+// redactRequested reports whether the client asked with ?redact=true for
+// the values resolved by the secret template function to be replaced. An
+// invalid value is reported to the client.
+func redactRequested(w http.ResponseWriter, r *http.Request) (redact bool, ok bool) {
+	value := r.URL.Query().Get("redact")
+	if value == "" {
+		return false, true
+	}
+	redact, err := strconv.ParseBool(value)
+	if err != nil {
+		http.Error(w, "Invalid redact parameter", http.StatusBadRequest)
+		return false, false
+	}
+	return redact, true
+}
+
+// redactProvenance redacts the values an explanation reports.
+func redactProvenance(keys map[string]*utils.Provenance) {
+	for _, provenance := range keys {
+		provenance.Value = utils.GlobalSecrets.Redact(provenance.Value)
+		for i := range provenance.Overrides {
+			provenance.Overrides[i].Value = utils.GlobalSecrets.Redact(provenance.Overrides[i].Value)
+		}
+	}
+}
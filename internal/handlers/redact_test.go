@@ -0,0 +1,80 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedaction(t *testing.T) {
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"all.yaml":            "user: app\npassword: initial\n",
+		"datacenters/dc.yaml": "password: {{ secret \"db/password\" }}\ndsn: app:{{ secret \"db/password\" }}@db\n",
+	})
+	secrets := t.TempDir()
+	writeRepoFiles(t, secrets, map[string]string{"db/password": "l0ng-s3cr3t\n"})
+
+	activate(repo, testPatterns(), utils.DefaultHierarchy)
+	utils.GlobalSecrets = utils.NewSecretCache(&utils.DirSecretProvider{Root: secrets}, time.Minute)
+	defer func() {
+		utils.GlobalSecrets = nil
+		setup()
+	}()
+
+	t.Run("details", func(t *testing.T) {
+		h := handlers.DetailsHandler()
+		for query, expected := range map[string]map[string]interface{}{
+			"":             {"user": "app", "password": "l0ng-s3cr3t", "dsn": "app:l0ng-s3cr3t@db"},
+			"?redact=true": {"user": "app", "password": "[REDACTED]", "dsn": "app:[REDACTED]@db"},
+		} {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", "/details/fn-dc"+query, nil))
+
+			assert.Equal(t, http.StatusOK, rr.Code, query)
+			var got map[string]interface{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			assert.Equal(t, expected, got, query)
+		}
+	})
+
+	t.Run("explain", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handlers.ExplainHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/explain/fn-dc?redact=1", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "l0ng-s3cr3t")
+		assert.Contains(t, rr.Body.String(), `"initial"`)
+	})
+
+	t.Run("invalid redact parameter", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handlers.DetailsHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/details/fn-dc?redact=maybe", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,82 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"configNexus/internal/utils"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+type searchResponse struct {
+	Commit string   `json:"commit"`
+	Hosts  []string `json:"hosts"`
+	// Hosts that could not be rendered and were not searched
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// SearchHandler returns the known hosts whose merged configuration matches
+// the selector in the q parameter. Repeated q parameters must all match.
+func SearchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queries := r.URL.Query()["q"]
+		if len(queries) == 0 {
+			http.Error(w, "Missing selector", http.StatusBadRequest)
+			return
+		}
+
+		var selector utils.Selector
+		for _, query := range queries {
+			parsed, err := utils.ParseSelector(query)
+			if err != nil {
+				http.Error(w, "Invalid selector: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			selector = append(selector, parsed...)
+		}
+
+		identities, ok := clientIdentities(w, r)
+		if !ok {
+			return
+		}
+
+		snapshot, ok := acquireSnapshot(w, r)
+		if !ok {
+			return
+		}
+		defer snapshot.Release()
+
+		hosts, failures, err := utils.SearchHosts(snapshot, selector, identities)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to search hosts")
+			http.Error(w, "Failed to list hosts", http.StatusInternalServerError)
+			return
+		}
+
+		jsonData, err := json.Marshal(searchResponse{Commit: snapshot.Commit, Hosts: hosts, Errors: failures})
+		if err != nil {
+			http.Error(w, "Failed to convert to JSON", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonData)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchHandler(t *testing.T) {
+	repo := t.TempDir()
+	writeRepoFiles(t, repo, map[string]string{
+		"all.yaml":            "ldap: ldap1\nfeatures:\n  vpn: false\n",
+		"devices/fn-dc.yaml":  "features:\n  vpn: true\n",
+		"devices/fn-dc2.yaml": "ldap: ldap2\n",
+	})
+	activate(repo, []utils.RegexPattern{{Name: "TestPattern", Regex: "^(?P<Function>fn)-(?P<Datacenter>dc[0-9]*)$"}}, utils.DefaultHierarchy)
+	defer teardown()
+
+	h := http.HandlerFunc(handlers.SearchHandler())
+
+	tests := []struct {
+		name           string
+		queries        []string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "equality",
+			queries:        []string{"ldap=ldap1"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"commit":"test","hosts":["fn-dc"]}`,
+		},
+		{
+			name:           "repeated selectors",
+			queries:        []string{"ldap~=^ldap", "features.vpn!=true"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"commit":"test","hosts":["fn-dc2"]}`,
+		},
+		{
+			name:           "no match",
+			queries:        []string{"!ldap"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"commit":"test","hosts":[]}`,
+		},
+		{
+			name:           "missing selector",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Missing selector\n",
+		},
+		{
+			name:           "invalid selector",
+			queries:        []string{"ldap~=("},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/search?"+url.Values{"q": tt.queries}.Encode(), nil)
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package handlers_test
+
+import (
+	"configNexus/internal/handlers"
+	"configNexus/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusHandler(t *testing.T) {
+	lastFetch := time.Date(2023, 9, 12, 10, 21, 44, 0, time.UTC)
+	h := http.HandlerFunc(handlers.StatusHandler(func() []utils.RepoStatus {
+		return []utils.RepoStatus{{
+			Branch:              "main",
+			Commit:              "59b20b8d5c6ff8d09518454d4dd8b7a30f095ab5",
+			LastFetch:           lastFetch,
+			LastError:           "context deadline exceeded",
+			ConsecutiveFailures: 2,
+		}}
+	}))
+
+	req := httptest.NewRequest("GET", "/status", nil)
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var got []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, []map[string]interface{}{{
+		"branch":               "main",
+		"commit":               "59b20b8d5c6ff8d09518454d4dd8b7a30f095ab5",
+		"last_fetch":           "2023-09-12T10:21:44Z",
+		"last_error":           "context deadline exceeded",
+		"consecutive_failures": float64(2),
+	}}, got)
+}
@@ -0,0 +1,121 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxWebhookPayload limits the size of push event bodies we accept
+const maxWebhookPayload = 10 << 20
+
+// pushEvent holds the fields GitHub, GitLab and Gitea push payloads share
+type pushEvent struct {
+	Ref string `json:"ref"`
+}
+
+// WebhookHandler receives push events from GitHub, GitLab and Gitea and
+// triggers an immediate refresh when the pushed branch is being served.
+// Requests must be signed (GitHub, Gitea) or carry the token (GitLab) that
+// was configured as secret. trigger schedules the refresh of a branch and
+// reports whether the branch is being served.
+func WebhookHandler(secret string, trigger func(branch string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+		if err != nil {
+			http.Error(w, "Failed to read payload", http.StatusBadRequest)
+			return
+		}
+
+		event, ok := verifyWebhook(r.Header, body, secret)
+		if !ok {
+			log.Warn().Str("Remote", r.RemoteAddr).Msg("Rejected webhook with invalid signature")
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		if !isPushEvent(event) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("Ignored event"))
+			return
+		}
+
+		var push pushEvent
+		if err := json.Unmarshal(body, &push); err != nil {
+			http.Error(w, "Invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		branch, isBranch := strings.CutPrefix(push.Ref, "refs/heads/")
+		if !isBranch || !trigger(branch) {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("Ignored ref"))
+			return
+		}
+
+		log.Info().Str("Branch", branch).Msg("Webhook triggered refresh")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Refresh scheduled"))
+	}
+}
+
+// verifyWebhook checks the signature or token of a webhook request and
+// returns the name of the event it carries
+func verifyWebhook(header http.Header, body []byte, secret string) (string, bool) {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		// Gitea signs with the hex HMAC-SHA256 of the body and no prefix
+		return header.Get("X-Gitea-Event"), validHMAC(header.Get("X-Gitea-Signature"), body, secret)
+	case header.Get("X-Gitlab-Event") != "":
+		// GitLab sends the secret token itself
+		token := header.Get("X-Gitlab-Token")
+		return header.Get("X-Gitlab-Event"), subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	case header.Get("X-GitHub-Event") != "":
+		signature, found := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		return header.Get("X-GitHub-Event"), found && validHMAC(signature, body, secret)
+	}
+	return "", false
+}
+
+func validHMAC(signature string, body []byte, secret string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func isPushEvent(event string) bool {
+	return event == "push" || event == "Push Hook"
+}
@@ -0,0 +1,178 @@
+package handlers_test
+
+import (
+	"bytes"
+	"configNexus/internal/handlers"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const webhookSecret = "s3cr3t"
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func readPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	return body
+}
+
+func TestWebhookHandler(t *testing.T) {
+	var triggered []string
+	trigger := func(branch string) bool {
+		triggered = append(triggered, branch)
+		return branch == "main" || branch == "staging"
+	}
+	h := http.HandlerFunc(handlers.WebhookHandler(webhookSecret, trigger))
+
+	github := readPayload(t, "github_push.json")
+	gitlab := readPayload(t, "gitlab_push.json")
+	gitea := readPayload(t, "gitea_push.json")
+
+	tests := []struct {
+		name            string
+		method          string
+		body            []byte
+		headers         map[string]string
+		expectedStatus  int
+		expectedTrigger []string
+	}{
+		{
+			name: "GitHub push",
+			body: github,
+			headers: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": "sha256=" + sign(github, webhookSecret),
+			},
+			expectedStatus:  http.StatusAccepted,
+			expectedTrigger: []string{"main"},
+		},
+		{
+			name: "GitHub bad signature",
+			body: github,
+			headers: map[string]string{
+				"X-GitHub-Event":      "push",
+				"X-Hub-Signature-256": "sha256=" + sign(github, "wrong"),
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "GitHub missing signature",
+			body: github,
+			headers: map[string]string{
+				"X-GitHub-Event": "push",
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "GitHub ping is ignored",
+			body: []byte(`{"zen":"Keep it logically awesome."}`),
+			headers: map[string]string{
+				"X-GitHub-Event":      "ping",
+				"X-Hub-Signature-256": "sha256=" + sign([]byte(`{"zen":"Keep it logically awesome."}`), webhookSecret),
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name: "GitLab push",
+			body: gitlab,
+			headers: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": webhookSecret,
+			},
+			expectedStatus:  http.StatusAccepted,
+			expectedTrigger: []string{"main"},
+		},
+		{
+			name: "GitLab bad token",
+			body: gitlab,
+			headers: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": "wrong",
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Gitea push",
+			body: gitea,
+			headers: map[string]string{
+				"X-Gitea-Event":     "push",
+				"X-Gitea-Signature": sign(gitea, webhookSecret),
+			},
+			expectedStatus:  http.StatusAccepted,
+			expectedTrigger: []string{"staging"},
+		},
+		{
+			name: "Gitea bad signature",
+			body: gitea,
+			headers: map[string]string{
+				"X-Gitea-Event":     "push",
+				"X-Gitea-Signature": "not-hex",
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "tag push is ignored",
+			body: []byte(`{"ref":"refs/tags/v1.0.0"}`),
+			headers: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": webhookSecret,
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name: "unknown branch",
+			body: []byte(`{"ref":"refs/heads/feature"}`),
+			headers: map[string]string{
+				"X-Gitlab-Event": "Push Hook",
+				"X-Gitlab-Token": webhookSecret,
+			},
+			expectedStatus:  http.StatusAccepted,
+			expectedTrigger: []string{"feature"},
+		},
+		{
+			name:           "unknown sender",
+			body:           github,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "GET is not allowed",
+			method:         "GET",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			triggered = nil
+			method := tt.method
+			if method == "" {
+				method = "POST"
+			}
+			req := httptest.NewRequest(method, "/hooks/git", bytes.NewReader(tt.body))
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedTrigger, triggered)
+		})
+	}
+}
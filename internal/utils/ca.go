@@ -0,0 +1,517 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files of the CA directory
+const (
+	caCertFile            = "ca.pem"
+	caKeyFile             = "ca-key.pem"
+	caRevokedFile         = "revoked.yaml"
+	caBootstrapTokensFile = "bootstrap-tokens.yaml"
+	caLockFile            = ".lock"
+)
+
+// caLifetime is the validity of a newly created CA certificate
+const caLifetime = 10 * 365 * 24 * time.Hour
+
+// CertificateAuthority is the internal CA issuing short-lived client
+// certificates to hosts. Its key, the revoked serials and the bootstrap
+// tokens live in Dir so they survive restarts.
+type CertificateAuthority struct {
+	Dir  string
+	Cert *x509.Certificate
+	TTL  time.Duration // Lifetime of the issued certificates
+
+	key crypto.Signer
+
+	mutex          sync.Mutex
+	revoked        []revokedCertificate
+	revokedModTime time.Time
+}
+
+type revokedCertificate struct {
+	Serial    string    `yaml:"serial"` // Hexadecimal
+	RevokedAt time.Time `yaml:"revoked_at"`
+}
+
+type revocationFile struct {
+	Revoked []revokedCertificate `yaml:"revoked"`
+}
+
+// bootstrapToken allows a host matching the Hosts glob to enroll once. Only
+// the SHA-256 of the token is stored.
+type bootstrapToken struct {
+	Hash    string    `yaml:"hash"`
+	Hosts   string    `yaml:"hosts"`
+	Expires time.Time `yaml:"expires"`
+}
+
+type bootstrapTokensFile struct {
+	Tokens []bootstrapToken `yaml:"tokens"`
+}
+
+// GlobalCertificateAuthority is used by the HTTP handlers and the HTTPS
+// listener, nil when no CADir is configured.
+var GlobalCertificateAuthority *CertificateAuthority
+
+// LoadCertificateAuthority loads the CA kept in dir, creating the directory,
+// key and certificate on first use.
+func LoadCertificateAuthority(dir string, ttl time.Duration) (*CertificateAuthority, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+	if _, err := os.Stat(keyPath); errors.Is(err, fs.ErrNotExist) {
+		if err := createCA(certPath, keyPath); err != nil {
+			return nil, err
+		}
+		log.Info().Str("Dir", dir).Msg("Created internal CA")
+	}
+
+	cert, err := readPEM(certPath, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", certPath, err)
+	}
+	key, err := readPEM(keyPath, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok || !caCert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(signer.Public()) {
+		return nil, fmt.Errorf("%s does not belong to %s", keyPath, certPath)
+	}
+	return &CertificateAuthority{Dir: dir, Cert: caCert, TTL: ttl, key: signer}, nil
+}
+
+// createCA writes a new CA key and self-signed certificate. The certificate
+// is written first, so an existing key always has its certificate.
+func createCA(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ConfigNexus"}, CommonName: "ConfigNexus Internal CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	return writeFileAtomic(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
+
+// CertificatePEM returns the CA certificate clients verify their peers with.
+func (ca *CertificateAuthority) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})
+}
+
+// ParseCertificateRequest parses a PEM encoded CSR and returns it with the
+// hostname it asks for. The CSR must be signed by its key, name the host
+// as common name and ask for no other name.
+func ParseCertificateRequest(data []byte) (*x509.CertificateRequest, string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, "", errors.New("no PEM certificate request found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, "", err
+	}
+
+	hostname := strings.ToLower(csr.Subject.CommonName)
+	if hostname == "" {
+		return nil, "", errors.New("certificate request has no common name")
+	}
+	for _, name := range csr.DNSNames {
+		if !strings.EqualFold(name, hostname) {
+			return nil, "", fmt.Errorf("certificate request asks for the additional name %s", name)
+		}
+	}
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, "", errors.New("certificate request may only ask for a DNS name")
+	}
+	return csr, hostname, nil
+}
+
+// Sign issues a client certificate for hostname to the key of csr, valid
+// for the TTL of the CA.
+func (ca *CertificateAuthority) Sign(csr *x509.CertificateRequest, hostname string) (*x509.Certificate, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ca.TTL)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		// Tolerate clocks that are a little behind
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// IsRevoked reports whether cert was issued by this CA and revoked since.
+func (ca *CertificateAuthority) IsRevoked(cert *x509.Certificate) (bool, error) {
+	if !bytes.Equal(cert.RawIssuer, ca.Cert.RawSubject) {
+		return false, nil
+	}
+	revoked, err := ca.revocations()
+	if err != nil {
+		return false, err
+	}
+	serial := cert.SerialNumber.Text(16)
+	for _, entry := range revoked {
+		if entry.Serial == serial {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// verifyNotRevoked refuses revoked certificates during the TLS handshake.
+func (ca *CertificateAuthority) verifyNotRevoked(_ [][]byte, chains [][]*x509.Certificate) error {
+	for _, chain := range chains {
+		revoked, err := ca.IsRevoked(chain[0])
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return fmt.Errorf("certificate %s is revoked", chain[0].SerialNumber.Text(16))
+		}
+	}
+	return nil
+}
+
+// CRL returns the DER encoded revocation list of the CA. It is valid for
+// the TTL of the issued certificates.
+func (ca *CertificateAuthority) CRL() ([]byte, error) {
+	revoked, err := ca.revocations()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	list := &x509.RevocationList{
+		Number:     big.NewInt(now.Unix()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(ca.TTL),
+	}
+	for _, entry := range revoked {
+		serial, ok := new(big.Int).SetString(entry.Serial, 16)
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid serial %s", caRevokedFile, entry.Serial)
+		}
+		list.RevokedCertificateEntries = append(list.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: entry.RevokedAt,
+		})
+	}
+	return x509.CreateRevocationList(rand.Reader, list, ca.Cert, ca.key)
+}
+
+// revocations returns the revoked certificates, reloading the revocation
+// file first if it was modified.
+func (ca *CertificateAuthority) revocations() ([]revokedCertificate, error) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	revokedPath := filepath.Join(ca.Dir, caRevokedFile)
+	info, err := os.Stat(revokedPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		ca.revoked, ca.revokedModTime = nil, time.Time{}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(ca.revokedModTime) {
+		return ca.revoked, nil
+	}
+
+	file, err := readRevocations(ca.Dir)
+	if err != nil {
+		return nil, err
+	}
+	ca.revoked = file.Revoked
+	ca.revokedModTime = info.ModTime()
+	return ca.revoked, nil
+}
+
+// RevokeCertificate records the certificate with serial as revoked in the
+// CA directory dir. A running server picks it up with the next handshake
+// or CRL.
+func RevokeCertificate(dir string, serial *big.Int) error {
+	if err := checkCADir(dir); err != nil {
+		return err
+	}
+	unlock, err := lockCADir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := readRevocations(dir)
+	if err != nil {
+		return err
+	}
+	hexSerial := serial.Text(16)
+	for _, entry := range file.Revoked {
+		if entry.Serial == hexSerial {
+			return nil
+		}
+	}
+	file.Revoked = append(file.Revoked, revokedCertificate{Serial: hexSerial, RevokedAt: time.Now().UTC()})
+	return writeYAML(filepath.Join(dir, caRevokedFile), file)
+}
+
+func readRevocations(dir string) (revocationFile, error) {
+	var file revocationFile
+	err := readYAML(filepath.Join(dir, caRevokedFile), &file)
+	return file, err
+}
+
+// CreateBootstrapToken adds a token that lets one host matching the hosts
+// glob enroll before ttl elapses, and returns it.
+func CreateBootstrapToken(dir, hosts string, ttl time.Duration) (string, error) {
+	if err := checkCADir(dir); err != nil {
+		return "", err
+	}
+	if _, err := path.Match(hosts, ""); err != nil {
+		return "", fmt.Errorf("hosts %s: %w", hosts, err)
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+
+	unlock, err := lockCADir(dir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	var file bootstrapTokensFile
+	if err := readYAML(filepath.Join(dir, caBootstrapTokensFile), &file); err != nil {
+		return "", err
+	}
+	file.Tokens = append(file.Tokens, bootstrapToken{
+		Hash:    hashToken(token),
+		Hosts:   hosts,
+		Expires: time.Now().Add(ttl).UTC(),
+	})
+	return token, writeYAML(filepath.Join(dir, caBootstrapTokensFile), file)
+}
+
+// SignWithBootstrapToken issues a certificate for hostname like Sign if
+// token lets the host enroll, reported by the second result. The token is
+// only used up once the certificate is signed, expired tokens are removed
+// at the same time.
+func (ca *CertificateAuthority) SignWithBootstrapToken(csr *x509.CertificateRequest, hostname, token string) (*x509.Certificate, bool, error) {
+	unlock, err := lockCADir(ca.Dir)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	tokensPath := filepath.Join(ca.Dir, caBootstrapTokensFile)
+	var file bootstrapTokensFile
+	if err := readYAML(tokensPath, &file); err != nil {
+		return nil, false, err
+	}
+
+	hash, now := hashToken(token), time.Now()
+	used := -1
+	for i, entry := range file.Tokens {
+		matches, _ := path.Match(entry.Hosts, hostname)
+		if now.Before(entry.Expires) && matches && subtle.ConstantTimeCompare([]byte(hash), []byte(entry.Hash)) == 1 {
+			used = i
+			break
+		}
+	}
+	if used < 0 {
+		return nil, false, nil
+	}
+
+	cert, err := ca.Sign(csr, hostname)
+	if err != nil {
+		return nil, true, err
+	}
+
+	kept := make([]bootstrapToken, 0, len(file.Tokens)-1)
+	for i, entry := range file.Tokens {
+		if i != used && now.Before(entry.Expires) {
+			kept = append(kept, entry)
+		}
+	}
+	file.Tokens = kept
+	if err := writeYAML(tokensPath, file); err != nil {
+		return nil, true, err
+	}
+	return cert, true, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkCADir catches a mistyped CA directory before files are added to it.
+func checkCADir(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, caCertFile)); err != nil {
+		return fmt.Errorf("%s is not a CA directory: %w", dir, err)
+	}
+	return nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// readPEM returns the content of the first PEM block of the file, which
+// must be of blockType.
+func readPEM(filePath, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s contains no %s", filePath, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// readYAML decodes a file of the CA directory, a missing file leaves out
+// unchanged.
+func readYAML(filePath string, out interface{}) error {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+	return nil
+}
+
+func writeYAML(filePath string, in interface{}) error {
+	data, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, data, 0o600)
+}
+
+// writeFileAtomic replaces a file so readers never see it half written.
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
@@ -0,0 +1,236 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCSR returns the PEM certificate request made from template and the
+// key that signed it.
+func testCSR(t *testing.T, template *x509.CertificateRequest) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate request: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), key
+}
+
+func TestLoadCertificateAuthority(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+
+	ca, err := LoadCertificateAuthority(dir, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ca.Cert.IsCA)
+	info, err := os.Stat(filepath.Join(dir, caKeyFile))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	reloaded, err := LoadCertificateAuthority(dir, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, ca.Cert.Raw, reloaded.Cert.Raw, "the CA is kept across restarts")
+
+	other, err := LoadCertificateAuthority(filepath.Join(t.TempDir(), "other"), time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, caCertFile), other.CertificatePEM(), 0o644))
+	_, err = LoadCertificateAuthority(dir, time.Hour)
+	assert.ErrorContains(t, err, "does not belong to")
+}
+
+func TestParseCertificateRequest(t *testing.T) {
+	valid, _ := testCSR(t, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "Web1.Example.com"},
+		DNSNames: []string{"web1.example.com"},
+	})
+	_, hostname, err := ParseCertificateRequest(valid)
+	assert.NoError(t, err)
+	assert.Equal(t, "web1.example.com", hostname)
+
+	extraName, _ := testCSR(t, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "web1.example.com"},
+		DNSNames: []string{"puppet.example.com"},
+	})
+	noName, _ := testCSR(t, &x509.CertificateRequest{DNSNames: []string{"web1.example.com"}})
+	withIP, _ := testCSR(t, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "web1.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	})
+	for name, data := range map[string][]byte{
+		"additional name": extraName,
+		"no common name":  noName,
+		"IP address":      withIP,
+		"not PEM":         []byte("web1.example.com"),
+	} {
+		_, _, err := ParseCertificateRequest(data)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestCertificateAuthority(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadCertificateAuthority(dir, time.Hour)
+	assert.NoError(t, err)
+
+	csrPEM, key := testCSR(t, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "web1.example.com"}})
+	csr, hostname, err := ParseCertificateRequest(csrPEM)
+	assert.NoError(t, err)
+
+	cert, err := ca.Sign(csr, hostname)
+	assert.NoError(t, err)
+
+	t.Run("issues short-lived client certificates", func(t *testing.T) {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Cert)
+		_, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		assert.NoError(t, err)
+		assert.Equal(t, "web1.example.com", cert.Subject.CommonName)
+		assert.Equal(t, []string{"web1.example.com"}, cert.DNSNames)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), cert.NotAfter, time.Minute)
+
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+		assert.Error(t, err, "the certificate can not serve TLS")
+	})
+
+	t.Run("revocation", func(t *testing.T) {
+		config, err := ClientTLSConfig(&Settings{ClientAuth: ClientAuthRequired}, ca)
+		assert.NoError(t, err)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = config
+		server.StartTLS()
+		defer server.Close()
+
+		get := func() error {
+			transport := server.Client().Transport.(*http.Transport).Clone()
+			transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+			response, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if err == nil {
+				response.Body.Close()
+			}
+			return err
+		}
+		assert.NoError(t, get())
+
+		revoked, err := ca.IsRevoked(cert)
+		assert.NoError(t, err)
+		assert.False(t, revoked)
+
+		assert.NoError(t, RevokeCertificate(dir, cert.SerialNumber))
+		assert.NoError(t, RevokeCertificate(dir, cert.SerialNumber), "revoking twice is harmless")
+		revoked, err = ca.IsRevoked(cert)
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+		assert.Error(t, get(), "a revoked certificate is refused")
+
+		der, err := ca.CRL()
+		assert.NoError(t, err)
+		crl, err := x509.ParseRevocationList(der)
+		assert.NoError(t, err)
+		assert.NoError(t, crl.CheckSignatureFrom(ca.Cert))
+		if assert.Len(t, crl.RevokedCertificateEntries, 1) {
+			assert.Equal(t, cert.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+		}
+	})
+
+	t.Run("bootstrap tokens", func(t *testing.T) {
+		token, err := CreateBootstrapToken(dir, "*.dc1.example.com", time.Hour)
+		assert.NoError(t, err)
+		tokens, err := os.ReadFile(filepath.Join(dir, caBootstrapTokensFile))
+		assert.NoError(t, err)
+		assert.NotContains(t, string(tokens), token, "only the hash is stored")
+
+		_, valid, err := ca.SignWithBootstrapToken(csr, "web1.dc2.example.com", token)
+		assert.NoError(t, err)
+		assert.False(t, valid, "the token is limited to its hosts")
+
+		_, valid, err = ca.SignWithBootstrapToken(&x509.CertificateRequest{}, "web1.dc1.example.com", token)
+		assert.Error(t, err, "a request without a public key cannot be signed")
+		assert.True(t, valid)
+
+		signed, valid, err := ca.SignWithBootstrapToken(csr, "web1.dc1.example.com", token)
+		assert.NoError(t, err)
+		assert.True(t, valid, "a failed signing keeps the token")
+		if assert.NotNil(t, signed) {
+			assert.Equal(t, "web1.dc1.example.com", signed.Subject.CommonName)
+		}
+
+		_, valid, err = ca.SignWithBootstrapToken(csr, "web1.dc1.example.com", token)
+		assert.NoError(t, err)
+		assert.False(t, valid, "a token can only be used once")
+
+		expired, err := CreateBootstrapToken(dir, "*", -time.Minute)
+		assert.NoError(t, err)
+		_, valid, err = ca.SignWithBootstrapToken(csr, "web1.dc1.example.com", expired)
+		assert.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("concurrent token use", func(t *testing.T) {
+		token, err := CreateBootstrapToken(dir, "*", time.Hour)
+		assert.NoError(t, err)
+
+		var wg sync.WaitGroup
+		var issued atomic.Int32
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// Each user opens the CA on its own, like the server and the CLI
+				other, err := LoadCertificateAuthority(dir, time.Hour)
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, valid, err := other.SignWithBootstrapToken(csr, "web1.example.com", token)
+				assert.NoError(t, err)
+				if valid {
+					issued.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.EqualValues(t, 1, issued.Load(), "a token is used by one request only")
+	})
+
+	t.Run("CA directory is checked", func(t *testing.T) {
+		_, err := CreateBootstrapToken(t.TempDir(), "*", time.Hour)
+		assert.Error(t, err)
+		assert.Error(t, RevokeCertificate(t.TempDir(), cert.SerialNumber))
+	})
+}
@@ -0,0 +1,75 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// Values of the ClientAuth setting
+const (
+	ClientAuthRequired = "required"
+	ClientAuthOptional = "optional"
+)
+
+// Values of the DetailsPolicy setting
+const (
+	DetailsPolicyOpen     = "open"
+	DetailsPolicySelfOnly = "self-only"
+)
+
+// ClientTLSConfig returns the TLS configuration of the HTTPS listener that
+// verifies client certificates against the ClientCAPath bundle and the
+// internal CA, nil when mutual TLS is not configured. Revoked certificates
+// of the internal CA are refused.
+func ClientTLSConfig(settings *Settings, ca *CertificateAuthority) (*tls.Config, error) {
+	if settings.ClientCAPath == "" && ca == nil {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if settings.ClientCAPath != "" {
+		bundle, err := os.ReadFile(settings.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("%s contains no PEM certificates", settings.ClientCAPath)
+		}
+	}
+
+	config := &tls.Config{ClientCAs: pool, MinVersion: tls.VersionTLS12}
+	switch settings.ClientAuth {
+	case ClientAuthRequired:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("ClientAuth must be %s or %s, got %q", ClientAuthRequired, ClientAuthOptional, settings.ClientAuth)
+	}
+	if ca != nil {
+		pool.AddCert(ca.Cert)
+		config.VerifyPeerCertificate = ca.verifyNotRevoked
+	}
+	return config, nil
+}
@@ -0,0 +1,126 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate issues a certificate for commonName, signed by parent or
+// self-signed as a CA when parent is nil.
+func testCertificate(t *testing.T, commonName string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	ca := testCertificate(t, "test CA", nil)
+	bundle := filepath.Join(t.TempDir(), "clients.pem")
+	assert.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o644))
+
+	t.Run("disabled without a bundle", func(t *testing.T) {
+		config, err := ClientTLSConfig(&Settings{ClientAuth: ClientAuthRequired}, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, config)
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		_, err := ClientTLSConfig(&Settings{ClientCAPath: bundle, ClientAuth: "sometimes"}, nil)
+		assert.Error(t, err)
+
+		empty := filepath.Join(t.TempDir(), "empty.pem")
+		assert.NoError(t, os.WriteFile(empty, []byte("no certificates\n"), 0o644))
+		_, err = ClientTLSConfig(&Settings{ClientCAPath: empty, ClientAuth: ClientAuthRequired}, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		config, err := ClientTLSConfig(&Settings{ClientCAPath: bundle, ClientAuth: ClientAuthOptional}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tls.VerifyClientCertIfGiven, config.ClientAuth)
+	})
+
+	t.Run("required", func(t *testing.T) {
+		config, err := ClientTLSConfig(&Settings{ClientCAPath: bundle, ClientAuth: ClientAuthRequired}, nil)
+		assert.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}))
+		server.TLS = config
+		server.StartTLS()
+		defer server.Close()
+
+		get := func(certificates ...tls.Certificate) (*http.Response, error) {
+			transport := server.Client().Transport.(*http.Transport).Clone()
+			transport.TLSClientConfig.Certificates = certificates
+			return (&http.Client{Transport: transport}).Get(server.URL)
+		}
+
+		response, err := get(testCertificate(t, "web1.example.com", &ca))
+		if assert.NoError(t, err) {
+			defer response.Body.Close()
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+		}
+
+		_, err = get()
+		assert.Error(t, err, "a client without a certificate is refused")
+		_, err = get(testCertificate(t, "web1.example.com", nil))
+		assert.Error(t, err, "a certificate of another CA is refused")
+	})
+}
@@ -0,0 +1,77 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+// ClientTokens identifies API clients by the bearer token they send. The
+// identity decides which sensitive keys a client may read.
+type ClientTokens struct {
+	tokens map[string]string // By identity
+}
+
+type clientTokensFile struct {
+	Clients map[string]string `yaml:"clients"`
+}
+
+// GlobalClientTokens is used by the HTTP handlers, nil when no
+// ClientTokensFile is configured and every client is anonymous.
+var GlobalClientTokens *ClientTokens
+
+// LoadClientTokens reads a YAML file mapping client identities to their
+// tokens.
+func LoadClientTokens(filePath string) (*ClientTokens, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var file clientTokensFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	for identity, token := range file.Clients {
+		if identity == "" || token == "" {
+			return nil, fmt.Errorf("%s: client %q needs an identity and a token", filePath, identity)
+		}
+	}
+	return &ClientTokens{tokens: file.Clients}, nil
+}
+
+// Identify returns the identity of the client the token belongs to. Every
+// token is compared, in constant time, so the timing does not tell which
+// one was close.
+func (t *ClientTokens) Identify(token string) (string, bool) {
+	if t == nil || token == "" {
+		return "", false
+	}
+	found := ""
+	for identity, expected := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			found = identity
+		}
+	}
+	return found, found != ""
+}
@@ -0,0 +1,58 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("clients:\n  ops: 0ps-t0ken\n  dba: dba-t0ken\n"), 0o600))
+
+	tokens, err := LoadClientTokens(path)
+	assert.NoError(t, err)
+
+	identity, ok := tokens.Identify("dba-t0ken")
+	assert.True(t, ok)
+	assert.Equal(t, "dba", identity)
+
+	for _, token := range []string{"", "dba-t0ke", "unknown"} {
+		_, ok := tokens.Identify(token)
+		assert.False(t, ok, token)
+	}
+
+	var none *ClientTokens
+	_, ok = none.Identify("dba-t0ken")
+	assert.False(t, ok)
+
+	t.Run("invalid files", func(t *testing.T) {
+		_, err := LoadClientTokens(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.Error(t, err)
+
+		empty := filepath.Join(t.TempDir(), "clients.yaml")
+		assert.NoError(t, os.WriteFile(empty, []byte("clients:\n  ops: \"\"\n"), 0o600))
+		_, err = LoadClientTokens(empty)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,190 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+)
+
+// MergeOp selects how a value from a more specific layer is combined with
+// the value inherited from the layers above it.
+type MergeOp string
+
+const (
+	MergeAppend  MergeOp = "append"
+	MergePrepend MergeOp = "prepend"
+	MergeReplace MergeOp = "replace"
+	MergeDelete  MergeOp = "delete"
+)
+
+// DeleteMarker is a scalar value that removes the key it is assigned to,
+// the same as tagging it with !delete.
+const DeleteMarker = "~delete"
+
+// mergeTags maps the YAML tags understood in template output to their operation.
+var mergeTags = map[string]MergeOp{
+	"!append":  MergeAppend,
+	"!prepend": MergePrepend,
+	"!replace": MergeReplace,
+	"!delete":  MergeDelete,
+}
+
+// MergeDirective wraps a value that was tagged with a merge directive in a
+// layer file. DeepMerge consumes directives, they never appear in its result.
+type MergeDirective struct {
+	Op    MergeOp
+	Value interface{}
+}
+
+// decodeYAML unmarshals a rendered template into a map, turning merge tags
+// and the delete marker into MergeDirective values.
+func decodeYAML(content []byte) (map[string]interface{}, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	root := document.Content[0]
+	if !hasDirectives(root) {
+		var yamlMap map[string]interface{}
+		if err := root.Decode(&yamlMap); err != nil {
+			return nil, err
+		}
+		return yamlMap, nil
+	}
+
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping at the top level", root.Line)
+	}
+	value, err := decodeNode(root)
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// hasDirectives reports whether a merge tag or delete marker appears anywhere below node.
+func hasDirectives(node *yaml.Node) bool {
+	if _, ok := mergeTags[node.Tag]; ok {
+		return true
+	}
+	if node.Kind == yaml.ScalarNode && node.Value == DeleteMarker {
+		return true
+	}
+	for _, child := range node.Content {
+		if hasDirectives(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeNode converts a YAML node into plain Go values, keeping merge directives.
+func decodeNode(node *yaml.Node) (interface{}, error) {
+	if op, ok := mergeTags[node.Tag]; ok {
+		if op == MergeDelete {
+			return MergeDirective{Op: MergeDelete}, nil
+		}
+		untagged := *node
+		untagged.Tag = ""
+		value, err := decodeNode(&untagged)
+		if err != nil {
+			return nil, err
+		}
+		return MergeDirective{Op: op, Value: value}, nil
+	}
+
+	switch node.Kind {
+	case yaml.AliasNode:
+		return decodeNode(node.Alias)
+	case yaml.MappingNode:
+		mapped := make(map[string]interface{}, len(node.Content)/2)
+		// Merge keys go first so that the explicit keys win
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Tag == "!!merge" {
+				if err := mergeKey(mapped, node.Content[i+1]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Tag == "!!merge" {
+				continue
+			}
+			var key string
+			if err := node.Content[i].Decode(&key); err != nil {
+				return nil, err
+			}
+			value, err := decodeNode(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			mapped[key] = value
+		}
+		return mapped, nil
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for _, child := range node.Content {
+			value, err := decodeNode(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case yaml.ScalarNode:
+		if node.Value == DeleteMarker {
+			return MergeDirective{Op: MergeDelete}, nil
+		}
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// mergeKey copies the mappings of a YAML merge key (<<) into mapped. The
+// value is a mapping or a sequence of mappings, earlier ones win.
+func mergeKey(mapped map[string]interface{}, value *yaml.Node) error {
+	sources := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		sources = value.Content
+	}
+	for i := len(sources) - 1; i >= 0; i-- {
+		decoded, err := decodeNode(sources[i])
+		if err != nil {
+			return err
+		}
+		source, ok := decoded.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("line %d: a merge key needs a mapping or a sequence of mappings", sources[i].Line)
+		}
+		for key, v := range source {
+			mapped[key] = v
+		}
+	}
+	return nil
+}
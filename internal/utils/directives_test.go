@@ -0,0 +1,103 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expected    map[string]interface{}
+		expectedErr bool
+	}{
+		{
+			name:     "plain yaml",
+			content:  "a: 1\nb: [x, y]\n",
+			expected: map[string]interface{}{"a": 1, "b": []interface{}{"x", "y"}},
+		},
+		{
+			name:     "empty document",
+			content:  "",
+			expected: nil,
+		},
+		{
+			name:    "merge tags",
+			content: "ports: !append [8102]\nntp: !prepend [ntp0]\nfeatures: !replace {vpn: true}\nowner: !delete\n",
+			expected: map[string]interface{}{
+				"ports":    MergeDirective{Op: MergeAppend, Value: []interface{}{8102}},
+				"ntp":      MergeDirective{Op: MergePrepend, Value: []interface{}{"ntp0"}},
+				"features": MergeDirective{Op: MergeReplace, Value: map[string]interface{}{"vpn": true}},
+				"owner":    MergeDirective{Op: MergeDelete},
+			},
+		},
+		{
+			name:    "delete marker",
+			content: "contact:\n  phone_number: ~delete\n  support: support@example.com\n",
+			expected: map[string]interface{}{
+				"contact": map[string]interface{}{
+					"phone_number": MergeDirective{Op: MergeDelete},
+					"support":      "support@example.com",
+				},
+			},
+		},
+		{
+			name:    "merge keys next to a directive",
+			content: "base: &b {a: 1, c: 0}\nx: {<<: *b, c: 2}\nextra: &e {d: 3, a: 4}\ny:\n  <<: [*b, *e]\nports: !append [1]\n",
+			expected: map[string]interface{}{
+				"base":  map[string]interface{}{"a": 1, "c": 0},
+				"x":     map[string]interface{}{"a": 1, "c": 2},
+				"extra": map[string]interface{}{"d": 3, "a": 4},
+				"y":     map[string]interface{}{"a": 1, "c": 0, "d": 3},
+				"ports": MergeDirective{Op: MergeAppend, Value: []interface{}{1}},
+			},
+		},
+		{
+			name:        "merge key with a scalar",
+			content:     "x: {<<: 1}\nports: !append [1]\n",
+			expectedErr: true,
+		},
+		{
+			name:        "directives need a mapping at the top level",
+			content:     "- !append [1]\n",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid yaml",
+			content:     "a: [1\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeYAML([]byte(tt.content))
+			if tt.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, got)
+			}
+		})
+	}
+}
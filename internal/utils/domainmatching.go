@@ -22,12 +22,13 @@ package utils
 import (
 	"gopkg.in/yaml.v3"
 	"os"
+	"regexp"
 	"sync"
 )
 
 type RegexPattern struct {
-	Name  string `yaml:"name"`
-	Regex string `yaml:"regex"`
+	Name  string `yaml:"name" json:"name"`
+	Regex string `yaml:"regex" json:"regex"`
 }
 
 type DomainMatching struct {
@@ -41,23 +42,33 @@ var (
 
 // LoadDomainMatchingPatterns loads the regex patterns from a YAML file
 func LoadDomainMatchingPatterns(filePath string) error {
-	data, err := os.ReadFile(filePath)
+	patterns, err := ReadDomainMatchingPatterns(filePath)
 	if err != nil {
 		return err
 	}
 
-	var dm DomainMatching
-	if err := yaml.Unmarshal(data, &dm); err != nil {
-		return err
-	}
-
 	GlobalDomainPatternsMutex.Lock()
-	GlobalDomainPatterns = dm.RegexPatterns
+	GlobalDomainPatterns = patterns
 	GlobalDomainPatternsMutex.Unlock()
 
 	return nil
 }
 
+// ReadDomainMatchingPatterns reads the regex patterns from a YAML file
+func ReadDomainMatchingPatterns(filePath string) ([]RegexPattern, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var dm DomainMatching
+	if err := yaml.Unmarshal(data, &dm); err != nil {
+		return nil, err
+	}
+
+	return dm.RegexPatterns, nil
+}
+
 // GetDomainPatterns safely returns a copy of the global domain patterns
 func GetDomainPatterns() []RegexPattern {
 	GlobalDomainPatternsMutex.Lock()
@@ -69,3 +80,35 @@ func GetDomainPatterns() []RegexPattern {
 
 	return copyPatterns
 }
+
+// HostMatch is the domain pattern a hostname matched and its named capture groups
+type HostMatch struct {
+	Pattern  RegexPattern
+	Captures map[string]string
+}
+
+// MatchHostname returns the first pattern matching the hostname, or nil if
+// none of them match
+func MatchHostname(hostname string, patterns []RegexPattern) (*HostMatch, error) {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, err
+		}
+
+		match := re.FindStringSubmatch(hostname)
+		if match == nil {
+			continue
+		}
+
+		// Create a map to hold the named matched content
+		captures := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if i != 0 && name != "" {
+				captures[name] = match[i]
+			}
+		}
+		return &HostMatch{Pattern: pattern, Captures: captures}, nil
+	}
+	return nil, nil
+}
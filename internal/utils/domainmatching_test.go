@@ -90,3 +90,40 @@ func TestGetDomainPatterns(t *testing.T) {
 		}
 	})
 }
+
+func TestMatchHostname(t *testing.T) {
+	patterns := []RegexPattern{
+		{Name: "Pattern1", Regex: "^(?P<Datacenter>[a-z]{3})(?P<Function>[a-z]+)(?P<Instance>\\d+)\\.mgt\\.prod\\.example\\.com$"},
+		{Name: "Pattern2", Regex: "^(?P<Function>[a-z]+)(?P<Instance>\\d+)\\.(?P<Datacenter>[a-z]{3})\\.example\\.com$"},
+	}
+
+	t.Run("first matching pattern wins", func(t *testing.T) {
+		match, err := MatchHostname("slcpostgresql1.mgt.prod.example.com", patterns)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if match == nil || match.Pattern.Name != "Pattern1" {
+			t.Fatalf("Expected Pattern1 to match, got %+v", match)
+		}
+		expected := map[string]string{"Datacenter": "slc", "Function": "postgresql", "Instance": "1"}
+		for key, value := range expected {
+			if match.Captures[key] != value {
+				t.Errorf("Expected capture %s=%s, got %s", key, value, match.Captures[key])
+			}
+		}
+	})
+
+	t.Run("no matching pattern", func(t *testing.T) {
+		match, err := MatchHostname("unknown", patterns)
+		if err != nil || match != nil {
+			t.Errorf("Expected no match and no error, got %+v, %v", match, err)
+		}
+	})
+
+	t.Run("invalid regex", func(t *testing.T) {
+		_, err := MatchHostname("unknown", []RegexPattern{{Name: "broken", Regex: "(invalid"}})
+		if err == nil {
+			t.Error("Expected an error for an invalid regex")
+		}
+	})
+}
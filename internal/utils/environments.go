@@ -0,0 +1,243 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"context"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rs/zerolog/log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+// Environments maps the branches that are served as environments to the
+// snapshot store of each branch.
+type Environments struct {
+	mutex   sync.RWMutex
+	stores  map[string]*SnapshotStore
+	Default string // Branch served when a request selects no environment
+}
+
+// GlobalEnvironments holds the environments the HTTP handlers serve from.
+var GlobalEnvironments Environments
+
+// Add registers the snapshot store of a branch.
+func (e *Environments) Add(branch string, store *SnapshotStore) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.stores == nil {
+		e.stores = make(map[string]*SnapshotStore)
+	}
+	e.stores[branch] = store
+}
+
+// Store returns the snapshot store of a branch, or nil if the branch is not
+// served. An empty branch selects the default environment.
+func (e *Environments) Store(branch string) *SnapshotStore {
+	if branch == "" || branch == e.Default {
+		return &GlobalSnapshots
+	}
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.stores[branch]
+}
+
+// Names returns the served branches in sorted order.
+func (e *Environments) Names() []string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	names := make([]string, 0, len(e.stores))
+	for name := range e.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Split separates a path of the form <branch>/<rest> into the served branch
+// and the rest. Branch names may contain slashes, so the longest served
+// branch wins.
+func (e *Environments) Split(p string) (string, string, bool) {
+	names := e.Names()
+	if e.Default != "" {
+		names = append(names, e.Default)
+	}
+
+	branch := ""
+	for _, name := range names {
+		if (p == name || strings.HasPrefix(p, name+"/")) && len(name) > len(branch) {
+			branch = name
+		}
+	}
+	if branch == "" {
+		return "", "", false
+	}
+	return branch, strings.TrimPrefix(p[len(branch):], "/"), true
+}
+
+// branchTracker clones and watches every branch of the config repository
+// that matches one of the configured branch patterns.
+type branchTracker struct {
+	settings *Settings
+	root     string
+	patterns []string
+	auth     *GitAuth
+	trigger  chan struct{}
+
+	mutex   sync.Mutex
+	tracked map[string]*Repo
+}
+
+var (
+	trackerMutex sync.Mutex
+	tracker      *branchTracker
+)
+
+func newBranchTracker(settings *Settings, root string) *branchTracker {
+	return &branchTracker{
+		settings: settings,
+		root:     root,
+		patterns: settings.RepoBranches,
+		auth:     NewGitAuth(settings.RepoCredentials, settings.RepoCredentialsFile),
+		trigger:  make(chan struct{}, 1),
+		tracked:  make(map[string]*Repo),
+	}
+}
+
+// matches reports whether branch should be served as an environment.
+func (t *branchTracker) matches(branch string) bool {
+	return MatchesAny(t.patterns, branch)
+}
+
+// track clones a branch and starts watching it, unless it is already
+// tracked. A branch whose clone could not be fetched is retried on the next
+// discovery.
+func (t *branchTracker) track(branch string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.tracked[branch]; ok {
+		return nil
+	}
+
+	store := &GlobalSnapshots
+	if branch != t.settings.RepoBranch {
+		store = &SnapshotStore{}
+	}
+
+	// Branch names may contain slashes
+	repo := NewRepo(t.settings.RepoAddress, branch, filepath.Join(t.root, url.PathEscape(branch)), store)
+	repo.SampleHosts = t.settings.ValidationHosts
+	repo.FetchTimeout = t.settings.FetchTimeout
+	repo.Auth = t.auth
+
+	err := repo.Clone()
+	if repo.repo == nil {
+		return err
+	}
+
+	// A clone whose commit failed validation is kept, the next good commit activates
+	t.tracked[branch] = repo
+	GlobalEnvironments.Add(branch, store)
+	watchRepo(repo)
+	go repo.Watch(PollSchedule{
+		Interval:       t.settings.PollInterval,
+		Jitter:         t.settings.PollJitter,
+		FailureBackoff: t.settings.FailureBackoff,
+		MaxBackoff:     t.settings.MaxFailureBackoff,
+	})
+	return err
+}
+
+// discover lists the branches of the remote and tracks the new ones that
+// match the branch patterns.
+func (t *branchTracker) discover() error {
+	auth, err := t.auth.Method(t.settings.RepoAddress)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if t.settings.FetchTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), t.settings.FetchTimeout)
+	}
+	defer cancel()
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{t.settings.RepoAddress}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() || !t.matches(ref.Name().Short()) {
+			continue
+		}
+		if err := t.track(ref.Name().Short()); err != nil {
+			log.Error().Err(err).Str("Branch", ref.Name().Short()).Msg("Failed to track branch")
+		}
+	}
+	return nil
+}
+
+// watch looks for new branches every poll interval, or earlier when
+// TriggerRefresh sees a push to an unknown matching branch. Branches that
+// disappear keep serving their last snapshot.
+func (t *branchTracker) watch() {
+	ticker := time.NewTicker(t.settings.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.trigger:
+		}
+		if err := t.discover(); err != nil {
+			log.Error().Err(err).Msg("Failed to list branches")
+		}
+	}
+}
+
+// triggerDiscovery schedules a discovery if branch is a new branch that
+// should be served. It reports whether branch matches the branch patterns.
+func triggerDiscovery(branch string) bool {
+	trackerMutex.Lock()
+	t := tracker
+	trackerMutex.Unlock()
+
+	if t == nil || !t.matches(branch) {
+		return false
+	}
+	select {
+	case t.trigger <- struct{}{}:
+	default:
+	}
+	return true
+}
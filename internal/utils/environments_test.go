@@ -0,0 +1,124 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// branch points a new branch of the test repository at commit.
+func (r *testRepo) branch(name, commit string) {
+	r.t.Helper()
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(name), plumbing.NewHash(commit))
+	if err := r.repo.Storer.SetReference(ref); err != nil {
+		r.t.Fatalf("Failed to create branch %s: %v", name, err)
+	}
+}
+
+func TestEnvironmentsSplit(t *testing.T) {
+	var environments Environments
+	environments.Default = "main"
+	environments.Add("release", &SnapshotStore{})
+	environments.Add("release/1.0", &SnapshotStore{})
+
+	tests := []struct {
+		path           string
+		expectedBranch string
+		expectedRest   string
+		expectedOk     bool
+	}{
+		{"main/details/web1", "main", "details/web1", true},
+		{"release/details/web1", "release", "details/web1", true},
+		{"release/1.0/details/web1", "release/1.0", "details/web1", true},
+		{"release/1.0", "release/1.0", "", true},
+		{"release-2/details/web1", "", "", false},
+		{"staging/details/web1", "", "", false},
+	}
+
+	for _, tt := range tests {
+		branch, rest, ok := environments.Split(tt.path)
+		assert.Equal(t, tt.expectedOk, ok, tt.path)
+		assert.Equal(t, tt.expectedBranch, branch, tt.path)
+		assert.Equal(t, tt.expectedRest, rest, tt.path)
+	}
+
+	assert.Same(t, &GlobalSnapshots, environments.Store(""))
+	assert.Same(t, &GlobalSnapshots, environments.Store("main"))
+	assert.Nil(t, environments.Store("staging"))
+}
+
+func TestBranchTracker(t *testing.T) {
+	source := newTestRepo(t, "main")
+	stagingCommit := source.commit(map[string]string{
+		"domains_regex.yaml": testDomainsRegex,
+		"all.yaml":           "environment: staging\n",
+	})
+	source.branch("staging", stagingCommit)
+	source.branch("feature/x", stagingCommit)
+	releaseCommit := source.commit(map[string]string{"all.yaml": "environment: release\n"})
+	source.branch("release/1.0", releaseCommit)
+
+	settings := &Settings{
+		RepoAddress:  source.path,
+		RepoBranch:   "main",
+		RepoBranches: []string{"staging", "release/*"},
+		PollInterval: time.Hour,
+	}
+	bt := newBranchTracker(settings, t.TempDir())
+	t.Cleanup(func() {
+		removeReadOnlyTree(bt.root)
+		watchedReposMutex.Lock()
+		watchedRepos = nil
+		watchedReposMutex.Unlock()
+		GlobalEnvironments = Environments{}
+	})
+
+	assert.NoError(t, bt.discover())
+	assert.Equal(t, []string{"release/1.0", "staging"}, GlobalEnvironments.Names())
+	assert.Equal(t, stagingCommit, GlobalEnvironments.Store("staging").Current().Commit)
+	assert.Equal(t, releaseCommit, GlobalEnvironments.Store("release/1.0").Current().Commit)
+	assert.Nil(t, GlobalEnvironments.Store("feature/x"))
+
+	statuses := WatchedRepoStatus()
+	assert.Len(t, statuses, 2)
+
+	t.Run("push to a new matching branch tracks it", func(t *testing.T) {
+		trackerMutex.Lock()
+		tracker = bt
+		trackerMutex.Unlock()
+		defer func() {
+			trackerMutex.Lock()
+			tracker = nil
+			trackerMutex.Unlock()
+		}()
+		go bt.watch()
+
+		source.branch("release/2.0", releaseCommit)
+		assert.False(t, TriggerRefresh("feature/y"))
+		assert.True(t, TriggerRefresh("release/2.0"))
+		assert.Eventually(t, func() bool {
+			store := GlobalEnvironments.Store("release/2.0")
+			return store != nil && store.Current() != nil
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
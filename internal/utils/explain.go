@@ -0,0 +1,132 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+// LayerValue is a value as it was set by a single layer file.
+type LayerValue struct {
+	Value interface{} `json:"value"`
+	Layer string      `json:"layer"`
+	File  string      `json:"file"`
+}
+
+// Provenance describes where the final value of a leaf key came from and
+// the values, oldest first, it overrode on the way.
+type Provenance struct {
+	LayerValue
+	Overrides []LayerValue `json:"overrides,omitempty"`
+}
+
+// ExplainLayers merges the layers like MergeLayers and records, for every
+// leaf of the result, the layer that supplied it. Leaf keys are dotted paths
+// into the merged map, lists are treated as a single leaf.
+func ExplainLayers(layers []Layer) map[string]*Provenance {
+	merged := make(map[string]interface{})
+	state := make(map[string]*Provenance)
+
+	for _, layer := range layers {
+		merged = DeepMerge(merged, layer.Data)
+
+		touched := make(map[string]bool)
+		touchedPaths("", layer.Data, touched)
+
+		previousPaths := make([]string, 0, len(state))
+		for path := range state {
+			previousPaths = append(previousPaths, path)
+		}
+		sort.Strings(previousPaths)
+
+		next := make(map[string]*Provenance)
+		for path, value := range flatten("", merged, make(map[string]interface{})) {
+			if previous, ok := state[path]; ok && !isTouched(path, touched) {
+				next[path] = previous
+				continue
+			}
+
+			provenance := &Provenance{LayerValue: LayerValue{Value: value, Layer: layer.Name, File: layer.Path}}
+			for _, previousPath := range previousPaths {
+				if previous := state[previousPath]; related(path, previousPath) {
+					provenance.Overrides = append(provenance.Overrides, previous.Overrides...)
+					provenance.Overrides = append(provenance.Overrides, previous.LayerValue)
+				}
+			}
+			next[path] = provenance
+		}
+		state = next
+	}
+
+	return state
+}
+
+// flatten collects the leaves of a merged map under their dotted path.
+func flatten(prefix string, data map[string]interface{}, out map[string]interface{}) map[string]interface{} {
+	for key, value := range data {
+		path := joinPath(prefix, key)
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flatten(path, nested, out)
+			continue
+		}
+		out[path] = value
+	}
+	return out
+}
+
+// touchedPaths collects the paths a layer sets a value for. Merge directives
+// always apply to the key they are attached to.
+func touchedPaths(prefix string, data map[string]interface{}, out map[string]bool) {
+	for key, value := range data {
+		path := joinPath(prefix, key)
+		if nested, ok := value.(map[string]interface{}); ok {
+			touchedPaths(path, nested, out)
+			continue
+		}
+		out[path] = true
+	}
+}
+
+// isTouched reports whether path or one of its parents was set by a layer.
+func isTouched(path string, touched map[string]bool) bool {
+	for {
+		if touched[path] {
+			return true
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
+
+// related reports whether one path is equal to, or nested below, the other.
+func related(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,89 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExplainLayers(t *testing.T) {
+	layers := []Layer{
+		{Name: "common", Path: "all.yaml", Data: map[string]interface{}{
+			"owner":   "superappteam",
+			"contact": map[string]interface{}{"phone_number": "555-0000", "support": "support@example.com"},
+			"ports":   []interface{}{8100},
+			"ldap":    "ldap1",
+		}},
+		{Name: "function", Path: "functions/postgresql.yaml", Data: map[string]interface{}{
+			"contact": map[string]interface{}{"phone_number": "555-1111"},
+			"ports":   MergeDirective{Op: MergeAppend, Value: []interface{}{8101}},
+		}},
+		{Name: "datacenter", Path: "datacenters/slc.yaml", Data: map[string]interface{}{
+			"contact": map[string]interface{}{"phone_number": "555-1234"},
+			"ldap":    MergeDirective{Op: MergeDelete},
+		}},
+	}
+
+	got := ExplainLayers(layers)
+
+	assert.Len(t, got, 4)
+	assert.Equal(t, &Provenance{
+		LayerValue: LayerValue{Value: "superappteam", Layer: "common", File: "all.yaml"},
+	}, got["owner"])
+	assert.Equal(t, &Provenance{
+		LayerValue: LayerValue{Value: "support@example.com", Layer: "common", File: "all.yaml"},
+	}, got["contact.support"])
+	assert.Equal(t, &Provenance{
+		LayerValue: LayerValue{Value: "555-1234", Layer: "datacenter", File: "datacenters/slc.yaml"},
+		Overrides: []LayerValue{
+			{Value: "555-0000", Layer: "common", File: "all.yaml"},
+			{Value: "555-1111", Layer: "function", File: "functions/postgresql.yaml"},
+		},
+	}, got["contact.phone_number"])
+	assert.Equal(t, &Provenance{
+		LayerValue: LayerValue{Value: []interface{}{8100, 8101}, Layer: "function", File: "functions/postgresql.yaml"},
+		Overrides: []LayerValue{
+			{Value: []interface{}{8100}, Layer: "common", File: "all.yaml"},
+		},
+	}, got["ports"])
+	assert.NotContains(t, got, "ldap")
+}
+
+func TestExplainLayersReplacedMap(t *testing.T) {
+	layers := []Layer{
+		{Name: "common", Path: "all.yaml", Data: map[string]interface{}{
+			"features": map[string]interface{}{"vpn": false, "firewall": true},
+		}},
+		{Name: "device", Path: "devices/host.yaml", Data: map[string]interface{}{
+			"features": MergeDirective{Op: MergeReplace, Value: map[string]interface{}{"vpn": true}},
+		}},
+	}
+
+	got := ExplainLayers(layers)
+
+	assert.Equal(t, &Provenance{
+		LayerValue: LayerValue{Value: true, Layer: "device", File: "devices/host.yaml"},
+		Overrides: []LayerValue{
+			{Value: false, Layer: "common", File: "all.yaml"},
+		},
+	}, got["features.vpn"])
+	assert.NotContains(t, got, "features.firewall")
+}
@@ -0,0 +1,300 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OutputFormat is a format a merged configuration can be returned in.
+type OutputFormat struct {
+	Name        string
+	ContentType string
+	MediaTypes  []string // Accepted in the Accept header
+	Aliases     []string // Accepted in addition to Name as ?format= value
+	Encode      func(data map[string]interface{}) ([]byte, error)
+}
+
+// OutputFormats lists the supported formats, the first one is the default.
+var OutputFormats = []OutputFormat{
+	{Name: "json", ContentType: "application/json", MediaTypes: []string{"application/json"}, Encode: encodeJSON},
+	{Name: "yaml", ContentType: "application/yaml", MediaTypes: []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}, Aliases: []string{"yml"}, Encode: encodeYAML},
+	{Name: "toml", ContentType: "application/toml", MediaTypes: []string{"application/toml"}, Encode: encodeTOML},
+	{Name: "dotenv", ContentType: "text/plain; charset=utf-8", MediaTypes: []string{"text/x-dotenv", "application/x-dotenv"}, Aliases: []string{"env"}, Encode: encodeDotenv},
+	{Name: "ini", ContentType: "text/plain; charset=utf-8", MediaTypes: []string{"text/x-ini", "application/x-ini"}, Encode: encodeINI},
+}
+
+// FormatError reports a value that the requested format cannot represent.
+type FormatError struct {
+	Format string
+	Key    string
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s cannot represent %s: %s", e.Format, e.Key, e.Reason)
+}
+
+// FormatByName returns the format with the given name or alias.
+func FormatByName(name string) (OutputFormat, bool) {
+	name = strings.ToLower(name)
+	for _, format := range OutputFormats {
+		if format.Name == name {
+			return format, true
+		}
+		for _, alias := range format.Aliases {
+			if alias == name {
+				return format, true
+			}
+		}
+	}
+	return OutputFormat{}, false
+}
+
+// NegotiateFormat picks the format from an Accept header, preferring media
+// types with a higher quality. An empty header or */* selects the default.
+func NegotiateFormat(accept string) (OutputFormat, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return OutputFormats[0], true
+	}
+
+	type candidate struct {
+		mediaType string
+		quality   float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{mediaType, quality})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].quality > candidates[j].quality })
+
+	for _, c := range candidates {
+		if c.mediaType == "*/*" || c.mediaType == "application/*" {
+			return OutputFormats[0], true
+		}
+		for _, format := range OutputFormats {
+			for _, mediaType := range format.MediaTypes {
+				if mediaType == c.mediaType {
+					return format, true
+				}
+			}
+		}
+	}
+	return OutputFormat{}, false
+}
+
+func encodeJSON(data map[string]interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func encodeYAML(data map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(data)
+}
+
+func encodeTOML(data map[string]interface{}) ([]byte, error) {
+	// TOML has no null value
+	if key, ok := findNull("", data); ok {
+		return nil, &FormatError{Format: "toml", Key: key, Reason: "null values are not supported"}
+	}
+	return toml.Marshal(data)
+}
+
+func findNull(prefix string, value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return prefix, true
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if path, ok := findNull(joinPath(prefix, key), v[key]); ok {
+				return path, true
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if path, ok := findNull(fmt.Sprintf("%s[%d]", prefix, i), item); ok {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+var (
+	dotenvInvalidChars = regexp.MustCompile(`[^A-Z0-9_]`)
+	dotenvPlainValue   = regexp.MustCompile(`^[A-Za-z0-9_./:@+-]*$`)
+)
+
+// encodeDotenv writes one KEY=value line per leaf. Nested keys and list
+// indexes are joined with underscores and upper-cased, so
+// contact.phone_number becomes CONTACT_PHONE_NUMBER and ports[0] PORTS_0.
+func encodeDotenv(data map[string]interface{}) ([]byte, error) {
+	variables := make(map[string]string)
+	origins := make(map[string]string)
+	if err := flattenDotenv("", "", data, variables, origins); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var output bytes.Buffer
+	for _, name := range names {
+		value := variables[name]
+		if !dotenvPlainValue.MatchString(value) {
+			value = shellQuote(value)
+		}
+		fmt.Fprintf(&output, "%s=%s\n", name, value)
+	}
+	return output.Bytes(), nil
+}
+
+// shellQuote single-quotes value so a shell sourcing the output takes it
+// literally, without expanding $VAR, $(...) or backticks.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func flattenDotenv(name, key string, value interface{}, variables, origins map[string]string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, child := range sortedKeys(v) {
+			if err := flattenDotenv(joinName(name, child), joinPath(key, child), v[child], variables, origins); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		for i, item := range v {
+			index := strconv.Itoa(i)
+			if err := flattenDotenv(joinName(name, index), fmt.Sprintf("%s[%d]", key, i), item, variables, origins); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return &FormatError{Format: "dotenv", Key: key, Reason: "variable names must not start with a digit"}
+	}
+	if other, ok := origins[name]; ok {
+		return &FormatError{Format: "dotenv", Key: key, Reason: fmt.Sprintf("%s and %s both map to %s", other, key, name)}
+	}
+	origins[name] = key
+	variables[name] = scalarString(value)
+	return nil
+}
+
+func joinName(prefix, key string) string {
+	key = dotenvInvalidChars.ReplaceAllString(strings.ToUpper(key), "_")
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
+
+// encodeINI writes top-level scalars before the first section and every map
+// as a section, nested maps as dotted sections. INI has no lists.
+func encodeINI(data map[string]interface{}) ([]byte, error) {
+	file := ini.Empty()
+	if err := addINISection(file, "", data); err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	if _, err := file.WriteTo(&output); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+func addINISection(file *ini.File, name string, data map[string]interface{}) error {
+	section := file.Section(name)
+	keys := sortedKeys(data)
+
+	// Keys first, a nested section would otherwise end the current one
+	for _, key := range keys {
+		value := data[key]
+		switch value.(type) {
+		case map[string]interface{}:
+			continue
+		case []interface{}:
+			return &FormatError{Format: "ini", Key: joinPath(name, key), Reason: "lists are not supported"}
+		}
+		if _, err := section.NewKey(key, scalarString(value)); err != nil {
+			return &FormatError{Format: "ini", Key: joinPath(name, key), Reason: err.Error()}
+		}
+	}
+	for _, key := range keys {
+		if nested, ok := data[key].(map[string]interface{}); ok {
+			if err := addINISection(file, joinPath(name, key), nested); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scalarString formats a leaf value for the line based formats.
+func scalarString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"function": "postgresql",
+		"instance": 1,
+		"ratio":    0.5,
+		"contact": map[string]interface{}{
+			"phone_number": "555-555-1234",
+			"support":      "datacenter support@example.com",
+		},
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	tests := []struct {
+		format   string
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			format:   "json",
+			data:     testConfig(),
+			expected: `{"contact":{"phone_number":"555-555-1234","support":"datacenter support@example.com"},"function":"postgresql","instance":1,"ratio":0.5}`,
+		},
+		{
+			format:   "yaml",
+			data:     testConfig(),
+			expected: "contact:\n    phone_number: 555-555-1234\n    support: datacenter support@example.com\nfunction: postgresql\ninstance: 1\nratio: 0.5\n",
+		},
+		{
+			format:   "toml",
+			data:     testConfig(),
+			expected: "function = 'postgresql'\ninstance = 1\nratio = 0.5\n\n[contact]\nphone_number = '555-555-1234'\nsupport = 'datacenter support@example.com'\n",
+		},
+		{
+			format:   "dotenv",
+			data:     testConfig(),
+			expected: "CONTACT_PHONE_NUMBER=555-555-1234\nCONTACT_SUPPORT='datacenter support@example.com'\nFUNCTION=postgresql\nINSTANCE=1\nRATIO=0.5\n",
+		},
+		{
+			format:   "dotenv",
+			data:     map[string]interface{}{"ports": []interface{}{8102, 8103}, "ldap-server": "ldap1", "empty": nil},
+			expected: "EMPTY=\nLDAP_SERVER=ldap1\nPORTS_0=8102\nPORTS_1=8103\n",
+		},
+		{
+			format:   "dotenv",
+			data:     map[string]interface{}{"command": "$(rm -rf ~) `id` it's $HOME"},
+			expected: "COMMAND='$(rm -rf ~) `id` it'\\''s $HOME'\n",
+		},
+		{
+			format:   "ini",
+			data:     testConfig(),
+			expected: "function = postgresql\ninstance = 1\nratio    = 0.5\n\n[contact]\nphone_number = 555-555-1234\nsupport      = datacenter support@example.com\n",
+		},
+		{
+			format:   "ini",
+			data:     map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": true}}},
+			expected: "[a]\n\n[a.b]\nc = true\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			format, ok := FormatByName(tt.format)
+			assert.True(t, ok)
+			output, err := format.Encode(tt.data)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(output))
+		})
+	}
+}
+
+func TestOutputFormatErrors(t *testing.T) {
+	tests := []struct {
+		format      string
+		data        map[string]interface{}
+		expectedKey string
+	}{
+		{format: "toml", data: map[string]interface{}{"a": map[string]interface{}{"b": nil}}, expectedKey: "a.b"},
+		{format: "toml", data: map[string]interface{}{"a": []interface{}{1, nil}}, expectedKey: "a[1]"},
+		{format: "dotenv", data: map[string]interface{}{"a.b": 1, "a": map[string]interface{}{"b": 2}}, expectedKey: "a.b"},
+		{format: "dotenv", data: map[string]interface{}{"1st": "x"}, expectedKey: "1st"},
+		{format: "ini", data: map[string]interface{}{"ports": []interface{}{8102}}, expectedKey: "ports"},
+		{format: "ini", data: map[string]interface{}{"a": map[string]interface{}{"ports": []interface{}{8102}}}, expectedKey: "a.ports"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format+" "+tt.expectedKey, func(t *testing.T) {
+			format, _ := FormatByName(tt.format)
+			_, err := format.Encode(tt.data)
+
+			var formatErr *FormatError
+			assert.True(t, errors.As(err, &formatErr), "expected a FormatError, got %v", err)
+			if formatErr != nil {
+				assert.Equal(t, tt.format, formatErr.Format)
+				assert.Equal(t, tt.expectedKey, formatErr.Key)
+			}
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept     string
+		expected   string
+		expectedOk bool
+	}{
+		{accept: "", expected: "json", expectedOk: true},
+		{accept: "*/*", expected: "json", expectedOk: true},
+		{accept: "application/yaml", expected: "yaml", expectedOk: true},
+		{accept: "text/html, application/x-yaml;q=0.9, */*;q=0.1", expected: "yaml", expectedOk: true},
+		{accept: "application/json;q=0.5, application/toml", expected: "toml", expectedOk: true},
+		{accept: "text/x-dotenv", expected: "dotenv", expectedOk: true},
+		{accept: "text/x-ini;q=0, application/json", expected: "json", expectedOk: true},
+		{accept: "text/html", expectedOk: false},
+	}
+
+	for _, tt := range tests {
+		format, ok := NegotiateFormat(tt.accept)
+		assert.Equal(t, tt.expectedOk, ok, tt.accept)
+		assert.Equal(t, tt.expected, format.Name, tt.accept)
+	}
+
+	format, ok := FormatByName("YML")
+	assert.True(t, ok)
+	assert.Equal(t, "yaml", format.Name)
+	_, ok = FormatByName("xml")
+	assert.False(t, ok)
+}
@@ -0,0 +1,207 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/rs/zerolog/log"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// GitCredentials configures how ConfigNexus authenticates to the config
+// repository. It can also be read from a credentials file, whose values
+// override the ones from Settings.
+type GitCredentials struct {
+	Username            string   `yaml:"username"`
+	Password            string   `yaml:"password"`
+	Token               string   `yaml:"token"` // Used as password, with a default username
+	SSHUser             string   `yaml:"ssh_user"`
+	SSHKeyPath          string   `yaml:"ssh_key_path"`
+	SSHKeyPassphrase    string   `yaml:"ssh_key_passphrase"`
+	KnownHostsPath      string   `yaml:"known_hosts_path"`
+	HostKeyFingerprints []string `yaml:"host_key_fingerprints"` // SHA256:... as printed by ssh-keygen -l
+}
+
+// tokenUsername is sent along with a token when no username is configured.
+// GitHub, GitLab and Gitea only look at the token.
+const tokenUsername = "x-access-token"
+
+// GitAuth builds the git transport authentication from the configured
+// credentials, reloading the credentials file whenever it changes.
+type GitAuth struct {
+	base            GitCredentials
+	credentialsFile string
+
+	mutex   sync.Mutex
+	current GitCredentials
+	modTime time.Time
+}
+
+// NewGitAuth returns a GitAuth using base, overridden by the content of
+// credentialsFile if one is given.
+func NewGitAuth(base GitCredentials, credentialsFile string) *GitAuth {
+	return &GitAuth{base: base, credentialsFile: credentialsFile, current: base}
+}
+
+// Method returns the authentication to use for repoURL. A nil GitAuth, or
+// one without credentials for the URL's protocol, returns nil so go-git
+// falls back to its defaults.
+func (a *GitAuth) Method(repoURL string) (transport.AuthMethod, error) {
+	if a == nil {
+		return nil, nil
+	}
+	credentials, err := a.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case "http", "https":
+		return credentials.httpAuth(), nil
+	case "ssh":
+		return credentials.sshAuth(endpoint.User)
+	}
+	return nil, nil
+}
+
+// credentials returns the current credentials, reloading the credentials
+// file first if it was modified.
+func (a *GitAuth) credentials() (GitCredentials, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.credentialsFile == "" {
+		return a.current, nil
+	}
+
+	info, err := os.Stat(a.credentialsFile)
+	if err != nil {
+		return GitCredentials{}, err
+	}
+	if info.ModTime().Equal(a.modTime) {
+		return a.current, nil
+	}
+
+	data, err := os.ReadFile(a.credentialsFile)
+	if err != nil {
+		return GitCredentials{}, err
+	}
+	loaded := a.base
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		return GitCredentials{}, fmt.Errorf("credentials file %s: %w", a.credentialsFile, err)
+	}
+
+	log.Info().Str("File", a.credentialsFile).Msg("Loaded git credentials")
+	a.current = loaded
+	a.modTime = info.ModTime()
+	return a.current, nil
+}
+
+func (c GitCredentials) httpAuth() transport.AuthMethod {
+	password := c.Password
+	if c.Token != "" {
+		password = c.Token
+	}
+	if password == "" {
+		return nil
+	}
+
+	username := c.Username
+	if username == "" {
+		username = tokenUsername
+	}
+	return &http.BasicAuth{Username: username, Password: password}
+}
+
+func (c GitCredentials) sshAuth(urlUser string) (transport.AuthMethod, error) {
+	if c.SSHKeyPath == "" && c.KnownHostsPath == "" && len(c.HostKeyFingerprints) == 0 {
+		return nil, nil
+	}
+
+	user := urlUser
+	if user == "" {
+		user = c.SSHUser
+	}
+	if user == "" {
+		user = "git"
+	}
+
+	hostKeyCallback, err := c.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.SSHKeyPath == "" {
+		// Host keys are pinned but the key itself comes from the ssh agent
+		auth, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, err
+		}
+		auth.HostKeyCallback = hostKeyCallback
+		return auth, nil
+	}
+
+	auth, err := ssh.NewPublicKeysFromFile(user, c.SSHKeyPath, c.SSHKeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("ssh key %s: %w", c.SSHKeyPath, err)
+	}
+	auth.HostKeyCallback = hostKeyCallback
+	return auth, nil
+}
+
+// hostKeyCallback verifies the server against the pinned fingerprints, the
+// configured known_hosts file, or the user's known_hosts files, in that order.
+func (c GitCredentials) hostKeyCallback() (gossh.HostKeyCallback, error) {
+	if len(c.HostKeyFingerprints) > 0 {
+		return FingerprintCallback(c.HostKeyFingerprints), nil
+	}
+	if c.KnownHostsPath != "" {
+		return ssh.NewKnownHostsCallback(c.KnownHostsPath)
+	}
+	return ssh.NewKnownHostsCallback()
+}
+
+// FingerprintCallback accepts a server whose host key has one of the
+// given SHA256 fingerprints.
+func FingerprintCallback(fingerprints []string) gossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		fingerprint := gossh.FingerprintSHA256(key)
+		for _, pinned := range fingerprints {
+			if pinned == fingerprint {
+				return nil
+			}
+		}
+		return errors.New("ssh: host key fingerprint " + fingerprint + " of " + hostname + " is not pinned")
+	}
+}
@@ -0,0 +1,316 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	nethttp "net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitHTTPServer serves the repositories below root through git http-backend,
+// requiring basic auth with the given credentials.
+func gitHTTPServer(t *testing.T, root, username, password string) *httptest.Server {
+	t.Helper()
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != username || pass != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			nethttp.Error(w, "Unauthorized", nethttp.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// sshServer is a minimal stand-in for an ssh git server. It accepts a
+// single client key and runs git-upload-pack for exec requests.
+type sshServer struct {
+	addr    string
+	hostKey gossh.PublicKey
+}
+
+func newSSHServer(t *testing.T, clientKey gossh.PublicKey) *sshServer {
+	t.Helper()
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack is not installed")
+	}
+
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	hostSigner, err := gossh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatalf("Failed to create host signer: %v", err)
+	}
+
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+
+	return &sshServer{addr: listener.Addr().String(), hostKey: hostSigner.PublicKey()}
+}
+
+func serveSSH(conn net.Conn, config *gossh.ServerConfig) {
+	_, channels, requests, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go gossh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range channelRequests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				// The payload is the command as an ssh string
+				command := string(req.Payload[4:])
+				req.Reply(true, nil)
+
+				service, path, _ := strings.Cut(command, " ")
+				cmd := exec.Command(service, strings.Trim(path, "'"))
+				cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+				status := uint32(0)
+				if err := cmd.Run(); err != nil {
+					status = 1
+				}
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint32(payload, status)
+				channel.SendRequest("exit-status", false, payload)
+				return
+			}
+		}()
+	}
+}
+
+// writeSSHKey writes a new PEM encoded client key and returns its public key.
+func writeSSHKey(t *testing.T, path string) gossh.PublicKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	public, err := gossh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to read public key: %v", err)
+	}
+	return public
+}
+
+func TestGitAuthHTTPS(t *testing.T) {
+	source := newTestRepo(t, "main")
+	commit := source.commit(map[string]string{"domains_regex.yaml": testDomainsRegex, "all.yaml": "a: 1\n"})
+	server := gitHTTPServer(t, filepath.Dir(source.path), "deploy", "t0ken")
+	repoURL := server.URL + "/" + filepath.Base(source.path) + "/.git"
+
+	tests := []struct {
+		name        string
+		credentials GitCredentials
+		expectedErr bool
+	}{
+		{name: "username and password", credentials: GitCredentials{Username: "deploy", Password: "t0ken"}},
+		{name: "token", credentials: GitCredentials{Username: "deploy", Token: "t0ken"}},
+		{name: "wrong password", credentials: GitCredentials{Username: "deploy", Password: "wrong"}, expectedErr: true},
+		{name: "no credentials", credentials: GitCredentials{}, expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var store SnapshotStore
+			repo := NewRepo(repoURL, "main", t.TempDir(), &store)
+			repo.Auth = NewGitAuth(tt.credentials, "")
+			t.Cleanup(func() { removeReadOnlyTree(repo.Dir) })
+
+			err := repo.Clone()
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, commit, store.Current().Commit)
+			assert.NoError(t, repo.Refresh())
+		})
+	}
+}
+
+func TestGitAuthSSH(t *testing.T) {
+	source := newTestRepo(t, "main")
+	commit := source.commit(map[string]string{"domains_regex.yaml": testDomainsRegex, "all.yaml": "a: 1\n"})
+
+	keyPath := filepath.Join(t.TempDir(), "id_rsa")
+	server := newSSHServer(t, writeSSHKey(t, keyPath))
+	repoURL := "ssh://git@" + server.addr + source.path
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, server.hostKey)
+	if err := os.WriteFile(knownHostsPath, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+	otherKeyPath := filepath.Join(t.TempDir(), "other_rsa")
+	writeSSHKey(t, otherKeyPath)
+
+	tests := []struct {
+		name        string
+		credentials GitCredentials
+		expectedErr bool
+	}{
+		{
+			name:        "pinned fingerprint",
+			credentials: GitCredentials{SSHKeyPath: keyPath, HostKeyFingerprints: []string{gossh.FingerprintSHA256(server.hostKey)}},
+		},
+		{
+			name:        "known_hosts file",
+			credentials: GitCredentials{SSHKeyPath: keyPath, KnownHostsPath: knownHostsPath},
+		},
+		{
+			name:        "wrong fingerprint",
+			credentials: GitCredentials{SSHKeyPath: keyPath, HostKeyFingerprints: []string{"SHA256:AAAA"}},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown client key",
+			credentials: GitCredentials{SSHKeyPath: otherKeyPath, KnownHostsPath: knownHostsPath},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var store SnapshotStore
+			repo := NewRepo(repoURL, "main", t.TempDir(), &store)
+			repo.FetchTimeout = 10 * time.Second
+			repo.Auth = NewGitAuth(tt.credentials, "")
+			t.Cleanup(func() { removeReadOnlyTree(repo.Dir) })
+
+			err := repo.Clone()
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, commit, store.Current().Commit)
+			assert.NoError(t, repo.Refresh())
+		})
+	}
+}
+
+func TestGitAuthCredentialsFile(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.yaml")
+	writeCredentials := func(content string, modTime time.Time) {
+		if err := os.WriteFile(credentialsFile, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write credentials: %v", err)
+		}
+		if err := os.Chtimes(credentialsFile, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+
+	auth := NewGitAuth(GitCredentials{Username: "deploy", Password: "from-settings"}, credentialsFile)
+	modTime := time.Now().Add(-time.Hour)
+
+	writeCredentials("password: first\n", modTime)
+	method, err := auth.Method("https://git.example.com/config.git")
+	assert.NoError(t, err)
+	assert.Equal(t, &http.BasicAuth{Username: "deploy", Password: "first"}, method)
+
+	writeCredentials("token: second\n", modTime.Add(time.Minute))
+	method, err = auth.Method("https://git.example.com/config.git")
+	assert.NoError(t, err)
+	assert.Equal(t, &http.BasicAuth{Username: "deploy", Password: "second"}, method)
+
+	writeCredentials("token: [\n", modTime.Add(2*time.Minute))
+	_, err = auth.Method("https://git.example.com/config.git")
+	assert.Error(t, err)
+
+	t.Run("no auth for local repositories", func(t *testing.T) {
+		method, err := NewGitAuth(GitCredentials{Password: "secret"}, "").Method("/srv/git/config.git")
+		assert.NoError(t, err)
+		assert.Nil(t, method)
+	})
+
+	t.Run("token without username", func(t *testing.T) {
+		method, err := NewGitAuth(GitCredentials{Token: "secret"}, "").Method("https://git.example.com/config.git")
+		assert.NoError(t, err)
+		assert.Equal(t, &http.BasicAuth{Username: tokenUsername, Password: "secret"}, method)
+	})
+}
@@ -20,9 +20,12 @@ Copyright (C) 2023 Operistech Inc.
 package utils
 
 import (
+	"context"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/rs/zerolog/log"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	git "github.com/go-git/go-git/v5"
@@ -30,59 +33,258 @@ import (
 
 var GlobalRepoPath string
 
-// ManageRepo TODO: Add functions for https oauth and for deployment keys
-func ManageRepo(repoURL, branch string) error {
+var (
+	watchedReposMutex sync.Mutex
+	watchedRepos      []*Repo
+)
 
-	// Create a unique directory within the system's temp folder
-	tempDir, err := os.MkdirTemp("", "confignexus")
+// Repo keeps a bare mirror of one branch of the config repository and
+// publishes every new commit of that branch as a Snapshot.
+type Repo struct {
+	URL       string
+	Branch    string
+	Dir       string // Holds the mirror and the snapshots
+	Snapshots *SnapshotStore
+
+	// SampleHosts are rendered to validate a commit before it is activated
+	SampleHosts []string
+	// FetchTimeout bounds a single clone or fetch, zero means no timeout
+	FetchTimeout time.Duration
+	// Auth authenticates clone and fetch, nil uses the go-git defaults
+	Auth *GitAuth
+
+	repo        *git.Repository
+	rejected    string // Last commit that failed validation
+	rejectedErr error
+	trigger     chan struct{}
+
+	statusMutex sync.Mutex
+	status      RepoStatus
+}
+
+// NewRepo returns a Repo for the branch that keeps its files below dir and
+// activates its snapshots in store.
+func NewRepo(repoURL, branch, dir string, store *SnapshotStore) *Repo {
+	return &Repo{URL: repoURL, Branch: branch, Dir: dir, Snapshots: store, trigger: make(chan struct{}, 1)}
+}
+
+func (r *Repo) mirrorPath() string {
+	return filepath.Join(r.Dir, "mirror.git")
+}
+
+func (r *Repo) snapshotsPath() string {
+	return filepath.Join(r.Dir, "snapshots")
+}
+
+// Clone creates the mirror and activates the commit the branch points to.
+func (r *Repo) Clone() error {
+	if err := os.MkdirAll(r.snapshotsPath(), 0o755); err != nil {
+		return err
+	}
+
+	auth, err := r.Auth.Method(r.URL)
 	if err != nil {
+		r.recordFetch(err)
 		return err
 	}
-	log.Debug().Msg(tempDir)
 
-	// Clone the repo
-	repo, err := git.PlainClone(tempDir, false, &git.CloneOptions{
-		URL:           repoURL,
-		ReferenceName: plumbing.NewBranchReferenceName(branch),
+	ctx, cancel := r.fetchContext()
+	defer cancel()
+
+	repo, err := git.PlainCloneContext(ctx, r.mirrorPath(), true, &git.CloneOptions{
+		URL:           r.URL,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(r.Branch),
+		SingleBranch:  true,
+	})
+	r.recordFetch(err)
+	if err != nil {
+		return err
+	}
+	r.repo = repo
+
+	return r.activateHead()
+}
+
+// Refresh fetches the branch and activates its commit if it changed.
+func (r *Repo) Refresh() error {
+	// Credentials may have changed since the last fetch
+	auth, err := r.Auth.Method(r.URL)
+	if err != nil {
+		r.recordFetch(err)
+		return err
+	}
+
+	ctx, cancel := r.fetchContext()
+	defer cancel()
+
+	err = r.repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       auth,
 	})
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	r.recordFetch(err)
+	if err != nil {
+		return err
+	}
+	return r.activateHead()
+}
+
+func (r *Repo) fetchContext() (context.Context, context.CancelFunc) {
+	if r.FetchTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), r.FetchTimeout)
+}
+
+// activateHead materializes the fetched commit of the branch into a new
+// snapshot and activates it, unless it is already active.
+func (r *Repo) activateHead() error {
+	ref, err := r.repo.Reference(plumbing.NewRemoteReferenceName("origin", r.Branch), true)
+	if err != nil {
+		return err
+	}
+
+	if current := r.Snapshots.Current(); current != nil && current.Commit == ref.Hash().String() {
+		return nil
+	}
+	// Don't validate the same broken commit again on every refresh
+	if r.rejected == ref.Hash().String() {
+		return r.rejectedErr
+	}
+
+	commit, err := r.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+
+	dir, err := materializeCommit(commit, r.snapshotsPath())
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := LoadSnapshot(commit.Hash.String(), dir)
+	if err == nil {
+		err = ValidateSnapshot(snapshot, r.SampleHosts)
+	}
 	if err != nil {
+		removeReadOnlyTree(dir)
+		if _, ok := err.(*ValidationError); !ok {
+			err = &ValidationError{Commit: commit.Hash.String(), Problems: []string{err.Error()}}
+		}
+		r.rejected, r.rejectedErr = commit.Hash.String(), err
 		return err
 	}
+	snapshot.owned = true
+
+	r.Snapshots.Activate(snapshot)
+	log.Info().Str("Branch", r.Branch).Str("Commit", snapshot.Commit).Msg("Activated snapshot")
+	return nil
+}
+
+// ManageRepo clones the configured branches of the repository and keeps
+// them up to date
+func ManageRepo(settings *Settings) error {
+
+	// Create a unique directory within the system's temp folder
+	tempDir, err := os.MkdirTemp("", "confignexus")
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg(tempDir)
 
 	// Set the global repo path
 	GlobalRepoPath = tempDir
+	GlobalEnvironments.Default = settings.RepoBranch
+
+	// Validating the first commit already decrypts secrets
+	if settings.SecretKeyFile != "" {
+		keys, err := LoadSecretKeys(settings.SecretKeyFile)
+		if err != nil {
+			return err
+		}
+		GlobalSecretKeys = keys
+	}
+	provider, err := NewSecretProvider(settings)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		GlobalSecrets = NewSecretCache(provider, settings.SecretCacheTTL)
+	}
 
-	if err := LoadDomainMatchingPatterns(GlobalRepoPath + "/domains_regex.yaml"); err != nil {
-		log.Fatal().Err(err).Msg("Failed to load domain matching patterns")
+	// The default branch has to be served before the server starts
+	t := newBranchTracker(settings, tempDir)
+	if err := t.track(settings.RepoBranch); err != nil {
 		return err
 	}
+	if len(t.patterns) == 0 {
+		return nil
+	}
+
+	// Start a goroutine that picks up new branches matching the patterns
+	trackerMutex.Lock()
+	tracker = t
+	trackerMutex.Unlock()
+	if err := t.discover(); err != nil {
+		log.Error().Err(err).Msg("Failed to list branches")
+	}
+	go t.watch()
 
-	// Start a goroutine to pull updates every 20 minutes
-	go func() {
-		ticker := time.NewTicker(20 * time.Minute)
-		defer ticker.Stop()
-
-		for {
-			<-ticker.C // Wait for the next tick
-			wt, err := repo.Worktree()
-			if err != nil {
-				log.Error().Err(err).Msg("Getting Worktree failed")
-			}
-			err = wt.Pull(&git.PullOptions{
-				RemoteName: "origin",
-			})
-			if err != nil && err != git.NoErrAlreadyUpToDate {
-				log.Error().Err(err).Msg("Failed to pull repository")
-			} else {
-				// Update domain matches if repo was updated.
-				if err := LoadDomainMatchingPatterns(GlobalRepoPath + "/domains_regex.yaml"); err != nil {
-					log.Fatal().Err(err).Msg("Failed to load domain matching patterns")
-					return
-				}
-			}
+	return nil
+}
 
+// watchRepo makes a repo reachable through TriggerRefresh
+func watchRepo(r *Repo) {
+	watchedReposMutex.Lock()
+	watchedRepos = append(watchedRepos, r)
+	watchedReposMutex.Unlock()
+}
+
+// TriggerRefresh schedules an immediate refresh of every watched repo that
+// tracks branch, or the discovery of branch if it is a new branch that
+// should be served. It reports whether branch is served.
+func TriggerRefresh(branch string) bool {
+	watchedReposMutex.Lock()
+	defer watchedReposMutex.Unlock()
+
+	found := false
+	for _, r := range watchedRepos {
+		if r.Branch != branch {
+			continue
+		}
+		found = true
+		// A refresh that is already pending covers this trigger as well
+		select {
+		case r.trigger <- struct{}{}:
+		default:
 		}
-	}()
+	}
+	if !found {
+		return triggerDiscovery(branch)
+	}
+	return found
+}
 
-	return nil
+// logRefreshError logs why a refresh failed. The last good snapshot stays active.
+func logRefreshError(err error) {
+	if validationErr, ok := err.(*ValidationError); ok {
+		log.Error().
+			Str("Commit", validationErr.Commit).
+			Strs("Problems", validationErr.Problems).
+			Msg("Refusing to activate commit, keeping the last good snapshot")
+		return
+	}
+	log.Error().Err(err).Msg("Failed to refresh repository")
+}
+
+// RemoveRepoPath removes the directory created by ManageRepo, including
+// the read-only snapshots inside it.
+func RemoveRepoPath() error {
+	if GlobalRepoPath == "" {
+		return nil
+	}
+	return removeReadOnlyTree(GlobalRepoPath)
 }
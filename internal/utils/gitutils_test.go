@@ -0,0 +1,204 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+// testRepo is a local git repository a Repo can clone from.
+type testRepo struct {
+	t    *testing.T
+	path string
+	repo *git.Repository
+}
+
+func newTestRepo(t *testing.T, branch string) *testRepo {
+	t.Helper()
+	path := t.TempDir()
+	repo, err := git.PlainInit(path, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))
+	if err := repo.Storer.SetReference(head); err != nil {
+		t.Fatalf("Failed to set HEAD: %v", err)
+	}
+	return &testRepo{t: t, path: path, repo: repo}
+}
+
+// commit writes the files and commits them, returning the commit hash.
+func (r *testRepo) commit(files map[string]string) string {
+	r.t.Helper()
+	writeFiles(r.t, r.path, files)
+
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatalf("Failed to get worktree: %v", err)
+	}
+	for name := range files {
+		if _, err := wt.Add(name); err != nil {
+			r.t.Fatalf("Failed to add %s: %v", name, err)
+		}
+	}
+	hash, err := wt.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		r.t.Fatalf("Failed to commit: %v", err)
+	}
+	return hash.String()
+}
+
+const testDomainsRegex = "regex_patterns:\n  - name: test\n    regex: \"^(?P<Function>[a-z]+)$\"\n"
+
+func TestRepo(t *testing.T) {
+	source := newTestRepo(t, "main")
+	firstCommit := source.commit(map[string]string{
+		"domains_regex.yaml": testDomainsRegex,
+		"all.yaml":           "version: 1\n",
+	})
+
+	var store SnapshotStore
+	repo := NewRepo(source.path, "main", t.TempDir(), &store)
+
+	t.Run("clone activates the branch head", func(t *testing.T) {
+		assert.NoError(t, repo.Clone())
+
+		snapshot := store.Current()
+		assert.Equal(t, firstCommit, snapshot.Commit)
+		assert.Equal(t, []RegexPattern{{Name: "test", Regex: "^(?P<Function>[a-z]+)$"}}, snapshot.Patterns)
+		assert.Equal(t, DefaultHierarchy, snapshot.Hierarchy)
+
+		content, err := os.ReadFile(filepath.Join(snapshot.Path, "all.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, "version: 1\n", string(content))
+	})
+
+	t.Run("snapshots are read-only", func(t *testing.T) {
+		snapshot := store.Current()
+		for _, name := range []string{"", "all.yaml"} {
+			info, err := os.Stat(filepath.Join(snapshot.Path, name))
+			assert.NoError(t, err)
+			assert.Zero(t, info.Mode().Perm()&0o222, "%s is writable", name)
+		}
+	})
+
+	t.Run("refresh without changes keeps the snapshot", func(t *testing.T) {
+		before := store.Current()
+		assert.NoError(t, repo.Refresh())
+		assert.Same(t, before, store.Current())
+	})
+
+	t.Run("refresh activates a new commit", func(t *testing.T) {
+		inFlight := store.Acquire()
+		secondCommit := source.commit(map[string]string{"all.yaml": "version: 2\n"})
+
+		assert.NoError(t, repo.Refresh())
+
+		snapshot := store.Current()
+		assert.Equal(t, secondCommit, snapshot.Commit)
+		content, err := os.ReadFile(filepath.Join(snapshot.Path, "all.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, "version: 2\n", string(content))
+
+		// The old snapshot stays readable until released
+		content, err = os.ReadFile(filepath.Join(inFlight.Path, "all.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, "version: 1\n", string(content))
+
+		inFlight.Release()
+		_, err = os.Stat(inFlight.Path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("commit with broken patterns is not activated", func(t *testing.T) {
+		before := store.Current()
+		source.commit(map[string]string{"domains_regex.yaml": "regex_patterns: [\n"})
+
+		assert.Error(t, repo.Refresh())
+		assert.Same(t, before, store.Current())
+	})
+
+	t.Run("commit with broken template is not activated", func(t *testing.T) {
+		before := store.Current()
+		broken := source.commit(map[string]string{
+			"domains_regex.yaml": testDomainsRegex,
+			"functions/web.yaml": "function: {{ .Function\n",
+		})
+
+		err := repo.Refresh()
+		var validationErr *ValidationError
+		assert.True(t, errors.As(err, &validationErr))
+		assert.Equal(t, broken, validationErr.Commit)
+		assert.Same(t, before, store.Current())
+
+		// A rejected commit is not validated again
+		assert.Same(t, err, repo.Refresh())
+	})
+
+	t.Run("fixed commit is activated", func(t *testing.T) {
+		fixed := source.commit(map[string]string{"functions/web.yaml": "function: {{ .Function }}\n"})
+
+		assert.NoError(t, repo.Refresh())
+		assert.Equal(t, fixed, store.Current().Commit)
+	})
+
+	// Let t.TempDir clean up the read-only snapshots
+	t.Cleanup(func() { removeReadOnlyTree(repo.Dir) })
+}
+
+func TestTriggerRefresh(t *testing.T) {
+	source := newTestRepo(t, "main")
+	source.commit(map[string]string{
+		"domains_regex.yaml": testDomainsRegex,
+		"all.yaml":           "version: 1\n",
+	})
+
+	var store SnapshotStore
+	repo := NewRepo(source.path, "main", t.TempDir(), &store)
+	assert.NoError(t, repo.Clone())
+	t.Cleanup(func() { removeReadOnlyTree(repo.Dir) })
+
+	watchRepo(repo)
+	defer func() {
+		watchedReposMutex.Lock()
+		watchedRepos = nil
+		watchedReposMutex.Unlock()
+	}()
+	go repo.Watch(PollSchedule{Interval: time.Hour})
+
+	assert.False(t, TriggerRefresh("other"))
+
+	latest := source.commit(map[string]string{"all.yaml": "version: 2\n"})
+	assert.True(t, TriggerRefresh("main"))
+	assert.Eventually(t, func() bool {
+		return store.Current().Commit == latest
+	}, 5*time.Second, 10*time.Millisecond)
+}
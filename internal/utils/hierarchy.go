@@ -0,0 +1,276 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// HierarchyLevel is one entry of hierarchy.yaml. Path is a template that is
+// rendered with the named capture groups of the matching domain pattern and
+// the requested Hostname.
+type HierarchyLevel struct {
+	Name     string `yaml:"name"`
+	Path     string `yaml:"path"`
+	Required bool   `yaml:"required"`
+}
+
+// Hierarchy is the content of hierarchy.yaml. Strict runs every layer
+// template with missingkey=error and turns levels whose capture group the
+// host does not have into errors instead of skipping them.
+type Hierarchy struct {
+	Levels []HierarchyLevel `yaml:"hierarchy"`
+	Strict bool             `yaml:"strict"`
+}
+
+// Layer is a hierarchy level resolved for a single host.
+type Layer struct {
+	Name string
+	Path string // Relative to the repository root
+	Data map[string]interface{}
+}
+
+// LayerError reports a layer file that could not be processed.
+type LayerError struct {
+	Layer string
+	Path  string
+	Err   error
+}
+
+func (e *LayerError) Error() string {
+	return fmt.Sprintf("layer %s (%s): %v", e.Layer, e.Path, e.Err)
+}
+
+func (e *LayerError) Unwrap() error {
+	return e.Err
+}
+
+// MissingCaptureError reports a hierarchy level whose path uses a capture
+// group the host has no value for.
+type MissingCaptureError struct {
+	Level   string
+	Capture string
+}
+
+func (e *MissingCaptureError) Error() string {
+	return fmt.Sprintf("hierarchy level %s needs capture group %s, the host has no value for it", e.Level, e.Capture)
+}
+
+// DefaultHierarchy is used when the config repo has no hierarchy.yaml.
+var DefaultHierarchy = []HierarchyLevel{
+	{Name: "common", Path: "all.yaml", Required: true},
+	{Name: "function", Path: "functions/{{ .Function }}.yaml"},
+	{Name: "datacenter", Path: "datacenters/{{ .Datacenter }}.yaml"},
+	{Name: "device", Path: "devices/{{ .Hostname }}.yaml"},
+}
+
+// ReadHierarchy reads the hierarchy from a YAML file, falling back to
+// DefaultHierarchy when the file does not exist
+func ReadHierarchy(filePath string) (Hierarchy, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return Hierarchy{Levels: DefaultHierarchy}, nil
+	}
+	if err != nil {
+		return Hierarchy{}, err
+	}
+
+	var h Hierarchy
+	if err := yaml.Unmarshal(data, &h); err != nil {
+		return Hierarchy{}, err
+	}
+	if err := validateHierarchy(h.Levels); err != nil {
+		return Hierarchy{}, err
+	}
+	return h, nil
+}
+
+func validateHierarchy(levels []HierarchyLevel) error {
+	if len(levels) == 0 {
+		return errors.New("hierarchy must contain at least one level")
+	}
+	for i, level := range levels {
+		if level.Name == "" || level.Path == "" {
+			return fmt.Errorf("hierarchy level %d needs both a name and a path", i+1)
+		}
+		if _, err := template.New(level.Name).Parse(level.Path); err != nil {
+			return fmt.Errorf("hierarchy level %s: %w", level.Name, err)
+		}
+	}
+	return nil
+}
+
+// ResolvePath renders the path template of the level. When the path
+// references a capture group the host does not have, the level does not
+// apply to the host and the error is a MissingCaptureError.
+func (l HierarchyLevel) ResolvePath(hostname string, captures map[string]string) (string, error) {
+	tmpl, err := template.New(l.Name).Option("missingkey=error").Parse(l.Path)
+	if err != nil {
+		return "", err
+	}
+
+	data := pathData(hostname, captures)
+	for _, field := range pathFields(tmpl.Tree.Root) {
+		if _, ok := data[field]; !ok {
+			return "", &MissingCaptureError{Level: l.Name, Capture: field}
+		}
+	}
+
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, data); err != nil {
+		return "", err
+	}
+
+	path := filepath.Clean(output.String())
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("hierarchy level %s resolves outside of the repository: %s", l.Name, path)
+	}
+	return path, nil
+}
+
+// pathData returns the values available to hierarchy path templates.
+func pathData(hostname string, captures map[string]string) map[string]string {
+	data := make(map[string]string, len(captures)+1)
+	for key, value := range captures {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	data["Hostname"] = hostname
+	return data
+}
+
+// pathFields returns the fields of dot a path template uses, in order.
+func pathFields(node parse.Node) []string {
+	var fields []string
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			fields = append(fields, pathFields(child)...)
+		}
+	case *parse.ActionNode:
+		fields = append(fields, pathFields(n.Pipe)...)
+	case *parse.IfNode:
+		fields = append(fields, pathFields(n.Pipe)...)
+		fields = append(fields, pathFields(n.List)...)
+		fields = append(fields, pathFields(n.ElseList)...)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				fields = append(fields, pathFields(arg)...)
+			}
+		}
+	case *parse.FieldNode:
+		fields = append(fields, n.Ident[0])
+	}
+	return fields
+}
+
+// ResolveLayers processes every hierarchy level of the snapshot that applies
+// to the host, in precedence order. Levels whose file does not exist are
+// skipped unless they are marked as required, levels whose capture group the
+// host does not have as well unless the snapshot is strict. Every layer is
+// rendered with the values merged from the layers before it as .Config.
+func ResolveLayers(s *Snapshot, hostname string, captures map[string]string) ([]Layer, error) {
+	return (&hostRenderer{snapshot: s, stack: []string{hostname}}).resolveLayers(hostname, captures)
+}
+
+// SkippedLevels returns the hierarchy levels that do not apply to the host
+// because it has no value for a capture group their path uses.
+func SkippedLevels(s *Snapshot, hostname string, captures map[string]string) []*MissingCaptureError {
+	var skipped []*MissingCaptureError
+	for _, level := range s.Hierarchy {
+		_, err := level.ResolvePath(hostname, captures)
+		if missing := s.skippedLevel(level, err); missing != nil {
+			skipped = append(skipped, missing)
+		}
+	}
+	return skipped
+}
+
+// skippedLevel returns the error of a level that is skipped for a missing
+// capture group, nil if err does not skip the level.
+func (s *Snapshot) skippedLevel(level HierarchyLevel, err error) *MissingCaptureError {
+	var missing *MissingCaptureError
+	if errors.As(err, &missing) && !level.Required && !s.Strict {
+		return missing
+	}
+	return nil
+}
+
+func (r *hostRenderer) resolveLayers(hostname string, captures map[string]string) ([]Layer, error) {
+	funcs := templateFuncs(r)
+	logTemplateData(captures, r.snapshot.Sensitive)
+
+	// Each layer sees the values merged from the layers before it
+	var layers []Layer
+	merged := make(map[string]interface{})
+	for _, level := range r.snapshot.Hierarchy {
+		path, err := level.ResolvePath(hostname, captures)
+		// Logged at debug level, searches render every host; /explain lists
+		// the skipped levels
+		if missing := r.snapshot.skippedLevel(level, err); missing != nil {
+			log.Debug().Str("Layer", level.Name).Str("Hostname", hostname).Str("Capture", missing.Capture).
+				Msg("Skipping layer, capture group missing")
+			continue
+		}
+		if err != nil {
+			return nil, &LayerError{Layer: level.Name, Path: level.Path, Err: err}
+		}
+
+		fullPath := filepath.Join(r.snapshot.Path, path)
+		if _, err := os.Stat(fullPath); err != nil && !level.Required {
+			continue
+		}
+
+		funcs["config"] = lookupConfig(merged)
+		data, err := processTemplate(fullPath, captures, merged, funcs, r.snapshot.Strict)
+		if err != nil {
+			return nil, &LayerError{Layer: level.Name, Path: path, Err: err}
+		}
+		layers = append(layers, Layer{Name: level.Name, Path: path, Data: data})
+		merged = DeepMerge(merged, data)
+	}
+	return layers, nil
+}
+
+// MergeLayers deep merges the layers in order, later layers taking precedence.
+func MergeLayers(layers []Layer) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for _, layer := range layers {
+		merged = DeepMerge(merged, layer.Data)
+	}
+	return merged
+}
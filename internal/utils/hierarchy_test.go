@@ -0,0 +1,219 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates the given files below root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestReadHierarchy(t *testing.T) {
+	t.Run("missing file falls back to the default hierarchy", func(t *testing.T) {
+		h, err := ReadHierarchy(filepath.Join(t.TempDir(), "hierarchy.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, Hierarchy{Levels: DefaultHierarchy}, h)
+	})
+
+	t.Run("read levels from YAML file", func(t *testing.T) {
+		tempFile, err := createTempYAMLFile(`
+strict: true
+hierarchy:
+  - name: common
+    path: all.yaml
+    required: true
+  - name: environment
+    path: "environments/{{ .Environment }}.yaml"
+  - name: device
+    path: "devices/{{ .Hostname }}.yaml"
+`)
+		if err != nil {
+			t.Fatalf("Failed to create temporary YAML file: %v", err)
+		}
+		defer os.Remove(tempFile)
+
+		h, err := ReadHierarchy(tempFile)
+		assert.NoError(t, err)
+		assert.Equal(t, Hierarchy{
+			Levels: []HierarchyLevel{
+				{Name: "common", Path: "all.yaml", Required: true},
+				{Name: "environment", Path: "environments/{{ .Environment }}.yaml"},
+				{Name: "device", Path: "devices/{{ .Hostname }}.yaml"},
+			},
+			Strict: true,
+		}, h)
+	})
+
+	t.Run("invalid levels are rejected", func(t *testing.T) {
+		for _, content := range []string{
+			"hierarchy: []\n",
+			"hierarchy:\n  - name: common\n",
+			"hierarchy:\n  - name: broken\n    path: \"{{ .Function\"\n",
+		} {
+			tempFile, err := createTempYAMLFile(content)
+			if err != nil {
+				t.Fatalf("Failed to create temporary YAML file: %v", err)
+			}
+			defer os.Remove(tempFile)
+
+			_, err = ReadHierarchy(tempFile)
+			assert.Error(t, err, content)
+		}
+	})
+}
+
+func TestHierarchyLevelResolvePath(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		captures        map[string]string
+		expected        string
+		expectedMissing string
+		expectedErr     bool
+	}{
+		{
+			name:     "capture groups and hostname",
+			path:     "{{ .Region }}/{{ .Role }}/{{ .Hostname }}.yaml",
+			captures: map[string]string{"Region": "us", "Role": "db"},
+			expected: "us/db/host1.yaml",
+		},
+		{
+			name:            "missing capture group",
+			path:            "functions/{{ .Function }}.yaml",
+			captures:        map[string]string{},
+			expectedMissing: "Function",
+		},
+		{
+			name:            "empty capture group",
+			path:            "{{ .Region }}/{{ .Function }}.yaml",
+			captures:        map[string]string{"Region": "us", "Function": ""},
+			expectedMissing: "Function",
+		},
+		{
+			name:        "path escaping the repository",
+			path:        "../{{ .Function }}.yaml",
+			captures:    map[string]string{"Function": "web"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level := HierarchyLevel{Name: "test", Path: tt.path}
+			got, err := level.ResolvePath("host1", tt.captures)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			if tt.expectedMissing != "" {
+				assert.Equal(t, &MissingCaptureError{Level: "test", Capture: tt.expectedMissing}, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestResolveLayers(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"all.yaml":                 "environment: default\nregion: none\n",
+		"environments/prod.yaml":   "environment: {{ .Environment }}\n",
+		"regions/us.yaml":          "region: {{ .Region }}\n",
+		"devices/db1.us.prod.yaml": "role: db\n",
+		"broken/prod.yaml":         "key: {{ .Environment\n",
+	})
+	levels := []HierarchyLevel{
+		{Name: "common", Path: "all.yaml", Required: true},
+		{Name: "environment", Path: "environments/{{ .Environment }}.yaml"},
+		{Name: "region", Path: "regions/{{ .Region }}.yaml"},
+		{Name: "role", Path: "roles/{{ .Role }}.yaml"},
+		{Name: "device", Path: "devices/{{ .Hostname }}.yaml"},
+	}
+	captures := map[string]string{"Environment": "prod", "Region": "us"}
+
+	t.Run("layers are resolved in order", func(t *testing.T) {
+		layers, err := ResolveLayers(&Snapshot{Path: repo, Hierarchy: levels}, "db1.us.prod", captures)
+		assert.NoError(t, err)
+
+		var names []string
+		for _, layer := range layers {
+			names = append(names, layer.Name)
+		}
+		assert.Equal(t, []string{"common", "environment", "region", "device"}, names)
+		assert.Equal(t, map[string]interface{}{
+			"environment": "prod",
+			"region":      "us",
+			"role":        "db",
+		}, MergeLayers(layers))
+	})
+
+	t.Run("missing required layer", func(t *testing.T) {
+		required := []HierarchyLevel{{Name: "common", Path: "missing.yaml", Required: true}}
+		_, err := ResolveLayers(&Snapshot{Path: repo, Hierarchy: required}, "db1.us.prod", captures)
+
+		var layerErr *LayerError
+		assert.True(t, errors.As(err, &layerErr))
+		assert.Equal(t, "common", layerErr.Layer)
+	})
+
+	t.Run("skipped levels", func(t *testing.T) {
+		skipped := SkippedLevels(&Snapshot{Path: repo, Hierarchy: levels}, "db1.us.prod", captures)
+		assert.Equal(t, []*MissingCaptureError{{Level: "role", Capture: "Role"}}, skipped)
+		assert.Empty(t, SkippedLevels(&Snapshot{Path: repo, Hierarchy: levels, Strict: true}, "db1.us.prod", captures))
+	})
+
+	t.Run("missing capture group in strict mode", func(t *testing.T) {
+		strict := &Snapshot{Path: repo, Hierarchy: levels, Strict: true}
+		_, err := ResolveLayers(strict, "db1.us.prod", captures)
+
+		var missing *MissingCaptureError
+		assert.True(t, errors.As(err, &missing), "expected a MissingCaptureError, got %v", err)
+		if missing != nil {
+			assert.Equal(t, &MissingCaptureError{Level: "role", Capture: "Role"}, missing)
+		}
+	})
+
+	t.Run("broken layer template", func(t *testing.T) {
+		broken := []HierarchyLevel{{Name: "broken", Path: "broken/{{ .Environment }}.yaml"}}
+		_, err := ResolveLayers(&Snapshot{Path: repo, Hierarchy: broken}, "db1.us.prod", captures)
+
+		var layerErr *LayerError
+		assert.True(t, errors.As(err, &layerErr))
+		assert.Equal(t, "broken/prod.yaml", layerErr.Path)
+	})
+}
@@ -0,0 +1,140 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// hostRenderer renders hosts of a snapshot for the cross-host template
+// functions. stack holds the hosts being rendered, outermost first, so a
+// hostConfig call that leads back to one of them is reported as a cycle
+// instead of recursing forever.
+type hostRenderer struct {
+	snapshot *Snapshot
+	stack    []string
+}
+
+// CycleError reports hosts whose templates reference each other through
+// hostConfig.
+type CycleError struct {
+	Hosts []string
+}
+
+func (e *CycleError) Error() string {
+	return "hostConfig cycle: " + strings.Join(e.Hosts, " -> ")
+}
+
+var errNoSnapshot = errors.New("cross-host lookups are only available when rendering a snapshot")
+
+// templateFuncs returns the functions available to layer templates: the
+// function library, the cross-host lookups of r, config without any values
+// and secret. A nil renderer provides the same names for parsing, calling
+// the lookups fails.
+func templateFuncs(r *hostRenderer) template.FuncMap {
+	funcs := make(template.FuncMap, len(libraryFuncs)+4)
+	for name, fn := range libraryFuncs {
+		funcs[name] = fn
+	}
+	funcs["hosts"] = r.hosts
+	funcs["hostConfig"] = r.hostConfig
+	funcs["config"] = lookupConfig(nil)
+	funcs["secret"] = GlobalSecrets.Secret
+	return funcs
+}
+
+// render matches the hostname and merges its layers with hostname pushed
+// on the stack. The match is nil if no pattern matches the hostname.
+func (r *hostRenderer) render(hostname string) (*HostMatch, map[string]interface{}, error) {
+	for i, host := range r.stack {
+		if host == hostname {
+			cycle := append(append([]string{}, r.stack[i:]...), hostname)
+			return nil, nil, &CycleError{Hosts: cycle}
+		}
+	}
+
+	match, err := MatchHostname(hostname, r.snapshot.Patterns)
+	if err != nil || match == nil {
+		return nil, nil, err
+	}
+
+	child := &hostRenderer{snapshot: r.snapshot, stack: append(append([]string{}, r.stack...), hostname)}
+	layers, err := child.resolveLayers(hostname, match.Captures)
+	if err != nil {
+		return match, nil, err
+	}
+	return match, MergeLayers(layers), nil
+}
+
+// hosts returns, sorted, the known hosts whose capture groups have the
+// given values, e.g. hosts "Function=postgresql" "Datacenter=slc".
+// Without filters every known host that matches a pattern is returned.
+func (r *hostRenderer) hosts(filters ...string) ([]string, error) {
+	if r == nil {
+		return nil, errNoSnapshot
+	}
+
+	captures := make(map[string][]string, len(filters))
+	for _, filter := range filters {
+		capture, value, ok := strings.Cut(filter, "=")
+		if !ok || capture == "" {
+			return nil, fmt.Errorf("hosts: invalid filter %q, expected Capture=value", filter)
+		}
+		captures[capture] = append(captures[capture], value)
+	}
+
+	entries, err := ListHosts(r.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{}
+	for _, entry := range entries {
+		if !entry.Orphan && entry.MatchesCaptures(captures) {
+			hosts = append(hosts, entry.Hostname)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// hostConfig returns the merged configuration of another host. Results are
+// cached on the snapshot and shared, they must not be modified.
+func (r *hostRenderer) hostConfig(hostname string) (map[string]interface{}, error) {
+	if r == nil {
+		return nil, errNoSnapshot
+	}
+	if cached, ok := r.snapshot.hostConfigs.Load(hostname); ok {
+		return cached.(map[string]interface{}), nil
+	}
+
+	match, data, err := r.render(hostname)
+	if err != nil {
+		return nil, err
+	}
+	if match == nil {
+		return nil, fmt.Errorf("hostConfig: no matching pattern found for %s", hostname)
+	}
+	r.snapshot.hostConfigs.Store(hostname, data)
+	return data, nil
+}
@@ -0,0 +1,108 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostFuncs(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"all.yaml": "ip: 10.0.0.{{ .Instance }}\n",
+		"functions/web.yaml": "upstreams:{{ range hosts \"Function=db\" (printf \"Datacenter=%s\" .Datacenter) }}\n" +
+			"  - {{ . }}{{ end }}\n" +
+			"ldap: {{ (hostConfig (printf \"ldap1-%s\" .Datacenter)).ip }}\n",
+		"devices/web1-slc.yaml": "a: 1\n",
+		"hosts.yaml":            "hosts: [db1-slc, db2-slc, db3-lax, ldap1-slc, printer]\n",
+	})
+	snapshot := &Snapshot{
+		Path:      repo,
+		Patterns:  []RegexPattern{{Name: "host", Regex: "^(?P<Function>[a-z]+)(?P<Instance>\\d+)-(?P<Datacenter>[a-z]+)$"}},
+		Hierarchy: DefaultHierarchy,
+	}
+
+	t.Run("hosts and hostConfig", func(t *testing.T) {
+		_, data, err := RenderHost(snapshot, "web1-slc")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"a":         1,
+			"ip":        "10.0.0.1",
+			"upstreams": []interface{}{"db1-slc", "db2-slc"},
+			"ldap":      "10.0.0.1",
+		}, data)
+	})
+
+	t.Run("hosts filters", func(t *testing.T) {
+		r := &hostRenderer{snapshot: snapshot}
+
+		hosts, err := r.hosts()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"db1-slc", "db2-slc", "db3-lax", "ldap1-slc", "web1-slc"}, hosts)
+
+		hosts, err = r.hosts("Datacenter=lax", "Datacenter=slc", "Function=db")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"db1-slc", "db2-slc", "db3-lax"}, hosts)
+
+		hosts, err = r.hosts("Function=mail")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{}, hosts)
+
+		_, err = r.hosts("Function")
+		assert.Error(t, err)
+	})
+
+	t.Run("hostConfig of an unknown host", func(t *testing.T) {
+		_, err := (&hostRenderer{snapshot: snapshot}).hostConfig("printer")
+		assert.ErrorContains(t, err, "no matching pattern found for printer")
+	})
+
+	t.Run("cycles are detected", func(t *testing.T) {
+		cyclic := t.TempDir()
+		writeFiles(t, cyclic, map[string]string{
+			"all.yaml":              "a: 1\n",
+			"devices/app1-slc.yaml": "peer: {{ (hostConfig \"app2-slc\").name }}\nname: app1\n",
+			"devices/app2-slc.yaml": "peer: {{ (hostConfig \"app1-slc\").name }}\nname: app2\n",
+			"devices/own1-slc.yaml": "self: {{ (hostConfig \"own1-slc\").a }}\n",
+		})
+		snapshot := &Snapshot{Path: cyclic, Patterns: snapshot.Patterns, Hierarchy: DefaultHierarchy}
+
+		_, _, err := RenderHost(snapshot, "app1-slc")
+		var cycleErr *CycleError
+		assert.True(t, errors.As(err, &cycleErr), "expected a CycleError, got %v", err)
+		if cycleErr != nil {
+			assert.Equal(t, []string{"app1-slc", "app2-slc", "app1-slc"}, cycleErr.Hosts)
+		}
+
+		_, _, err = RenderHost(snapshot, "own1-slc")
+		assert.True(t, errors.As(err, &cycleErr), "expected a CycleError, got %v", err)
+		if cycleErr != nil {
+			assert.Equal(t, []string{"own1-slc", "own1-slc"}, cycleErr.Hosts)
+		}
+	})
+
+	t.Run("lookups need a snapshot", func(t *testing.T) {
+		_, err := ProcessTemplate(filepath.Join(repo, "functions", "web.yaml"), map[string]string{"Datacenter": "slc"})
+		assert.ErrorIs(t, err, errNoSnapshot)
+	})
+}
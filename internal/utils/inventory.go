@@ -0,0 +1,234 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// HostList is the content of a host list file, hosts.yaml or hosts/*.yaml,
+// naming hosts that have no device file of their own.
+type HostList struct {
+	Hosts []string `yaml:"hosts"`
+}
+
+// KnownHosts returns, sorted and without duplicates, the hostnames of the
+// repository at repoPath: the names of the files in devices/ and the
+// entries of the host list files.
+func KnownHosts(repoPath string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	devices, err := filepath.Glob(filepath.Join(repoPath, "devices", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		seen[strings.TrimSuffix(filepath.Base(device), ".yaml")] = true
+	}
+
+	lists, err := filepath.Glob(filepath.Join(repoPath, "hosts", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	lists = append([]string{filepath.Join(repoPath, "hosts.yaml")}, lists...)
+	for _, list := range lists {
+		hosts, err := readHostList(list)
+		if err != nil {
+			rel, _ := filepath.Rel(repoPath, list)
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+		for _, host := range hosts {
+			seen[host] = true
+		}
+	}
+
+	hosts := make([]string, 0, len(seen))
+	for host := range seen {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+func readHostList(filePath string) ([]string, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list HostList
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, host := range list.Hosts {
+		if host == "" || strings.ContainsAny(host, "/\\") {
+			return nil, fmt.Errorf("invalid hostname %q", host)
+		}
+	}
+	return list.Hosts, nil
+}
+
+// HostEntry is a known host and the domain pattern it matches. Orphans are
+// known hosts that match no pattern.
+type HostEntry struct {
+	Hostname string            `json:"hostname"`
+	Pattern  *RegexPattern     `json:"pattern"`
+	Captures map[string]string `json:"captures"`
+	Orphan   bool              `json:"orphan"`
+}
+
+// ListHosts matches every known host of the snapshot against its patterns.
+// The result is computed once per snapshot, which never changes, and must
+// not be modified.
+func ListHosts(s *Snapshot) ([]HostEntry, error) {
+	s.hostsOnce.Do(func() {
+		s.hosts, s.hostsErr = listHosts(s)
+	})
+	return s.hosts, s.hostsErr
+}
+
+func listHosts(s *Snapshot) ([]HostEntry, error) {
+	hosts, err := KnownHosts(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HostEntry, 0, len(hosts))
+	for _, hostname := range hosts {
+		match, err := MatchHostname(hostname, s.Patterns)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			entries = append(entries, HostEntry{Hostname: hostname, Captures: map[string]string{}, Orphan: true})
+			continue
+		}
+		entries = append(entries, HostEntry{Hostname: hostname, Pattern: &match.Pattern, Captures: match.Captures})
+	}
+	return entries, nil
+}
+
+// MatchesCaptures reports whether the host has, for every capture group in
+// filters, one of the listed values.
+func (e HostEntry) MatchesCaptures(filters map[string][]string) bool {
+	for capture, values := range filters {
+		value, ok := e.Captures[capture]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, wanted := range values {
+			if value == wanted {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// RenderHost matches the hostname against the patterns of the snapshot and
+// merges its layers, exactly like the details endpoint. The match is nil if
+// no pattern matches the hostname.
+func RenderHost(s *Snapshot, hostname string) (*HostMatch, map[string]interface{}, error) {
+	return (&hostRenderer{snapshot: s}).render(hostname)
+}
+
+var invalidGroupChars = regexp.MustCompile(`[^a-z0-9_]`)
+
+// groupName turns a capture group and its value into a valid Ansible group
+// name, e.g. Function=postgresql becomes function_postgresql.
+func groupName(capture, value string) string {
+	return invalidGroupChars.ReplaceAllString(strings.ToLower(capture+"_"+value), "_")
+}
+
+// AnsibleInventory builds an Ansible dynamic inventory of the known hosts of
+// the snapshot. Hosts are grouped by the values of their capture groups and
+// their hostvars are their merged configuration, masked for the client identities. Hosts
+// that match no pattern are listed in ungrouped without hostvars. Hosts that
+// fail to render are left out of the inventory and returned, by hostname,
+// with their errors.
+func AnsibleInventory(s *Snapshot, identities []string) (map[string]interface{}, map[string]string, error) {
+	hosts, err := KnownHosts(s.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostvars := make(map[string]interface{})
+	groups := make(map[string][]string)
+	var ungrouped []string
+	failures := make(map[string]string)
+
+	for _, hostname := range hosts {
+		match, data, err := RenderHost(s, hostname)
+		if err != nil {
+			failures[hostname] = err.Error()
+			continue
+		}
+		if match == nil {
+			ungrouped = append(ungrouped, hostname)
+			continue
+		}
+
+		hostvars[hostname] = s.Sensitive.Mask(data, identities)
+		grouped := false
+		for capture, value := range match.Captures {
+			if value == "" {
+				continue
+			}
+			name := groupName(capture, value)
+			groups[name] = append(groups[name], hostname)
+			grouped = true
+		}
+		if !grouped {
+			ungrouped = append(ungrouped, hostname)
+		}
+	}
+
+	inventory := map[string]interface{}{
+		"_meta": map[string]interface{}{"hostvars": hostvars},
+	}
+	children := make([]string, 0, len(groups)+1)
+	for name, members := range groups {
+		sort.Strings(members)
+		inventory[name] = map[string]interface{}{"hosts": members}
+		children = append(children, name)
+	}
+	sort.Strings(children)
+	if len(ungrouped) > 0 {
+		inventory["ungrouped"] = map[string]interface{}{"hosts": ungrouped}
+		children = append(children, "ungrouped")
+	}
+	inventory["all"] = map[string]interface{}{"children": children}
+	return inventory, failures, nil
+}
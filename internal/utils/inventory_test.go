@@ -0,0 +1,172 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKnownHosts(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name:     "no hosts",
+			files:    map[string]string{"all.yaml": "a: 1\n"},
+			expected: []string{},
+		},
+		{
+			name: "device files and host lists",
+			files: map[string]string{
+				"devices/web1.example.com.yaml": "a: 1\n",
+				"devices/notes.txt":             "not a device\n",
+				"hosts.yaml":                    "hosts: [db1.example.com, web1.example.com]\n",
+				"hosts/slc.yaml":                "hosts:\n  - slcdb2.example.com\n",
+			},
+			expected: []string{"db1.example.com", "slcdb2.example.com", "web1.example.com"},
+		},
+		{
+			name:        "broken host list",
+			files:       map[string]string{"hosts.yaml": "hosts: [\n"},
+			expectedErr: true,
+		},
+		{
+			name:        "hostname with a path",
+			files:       map[string]string{"hosts/dc.yaml": "hosts: [../all]\n"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := t.TempDir()
+			writeFiles(t, repo, tt.files)
+
+			hosts, err := KnownHosts(repo)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, hosts)
+		})
+	}
+}
+
+func TestAnsibleInventory(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"all.yaml":                "function: \"{{ .Function }}\"\n",
+		"datacenters/slc.yaml":    "ntp: ntp.slc.example.com\n",
+		"devices/web1-slc.yaml":   "owner: web-team\n",
+		"hosts.yaml":              "hosts: [db1-slc, db2-lax, printer]\n",
+		"functions/unused.yaml":   "a: 1\n",
+		"devices/unused-lax.yaml": "",
+	})
+	snapshot := &Snapshot{
+		Path:      repo,
+		Patterns:  []RegexPattern{{Name: "host", Regex: "^(?P<Function>[a-z]+)\\d*-(?P<Datacenter>[a-z]+)$"}},
+		Hierarchy: DefaultHierarchy,
+	}
+
+	inventory, failures, err := AnsibleInventory(snapshot, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, failures)
+	assert.Equal(t, map[string]interface{}{
+		"_meta": map[string]interface{}{
+			"hostvars": map[string]interface{}{
+				"db1-slc":    map[string]interface{}{"function": "db", "ntp": "ntp.slc.example.com"},
+				"db2-lax":    map[string]interface{}{"function": "db"},
+				"unused-lax": map[string]interface{}{"function": "unused", "a": 1},
+				"web1-slc":   map[string]interface{}{"function": "web", "ntp": "ntp.slc.example.com", "owner": "web-team"},
+			},
+		},
+		"all": map[string]interface{}{
+			"children": []string{"datacenter_lax", "datacenter_slc", "function_db", "function_unused", "function_web", "ungrouped"},
+		},
+		"datacenter_lax":  map[string]interface{}{"hosts": []string{"db2-lax", "unused-lax"}},
+		"datacenter_slc":  map[string]interface{}{"hosts": []string{"db1-slc", "web1-slc"}},
+		"function_db":     map[string]interface{}{"hosts": []string{"db1-slc", "db2-lax"}},
+		"function_unused": map[string]interface{}{"hosts": []string{"unused-lax"}},
+		"function_web":    map[string]interface{}{"hosts": []string{"web1-slc"}},
+		"ungrouped":       map[string]interface{}{"hosts": []string{"printer"}},
+	}, inventory)
+
+	t.Run("hosts that fail to render are left out", func(t *testing.T) {
+		writeFiles(t, repo, map[string]string{"devices/db1-slc.yaml": "a: {{ .Function\n"})
+		inventory, failures, err := AnsibleInventory(snapshot, nil)
+		assert.NoError(t, err)
+		assert.Contains(t, failures, "db1-slc")
+		assert.Len(t, failures, 1)
+
+		hostvars := inventory["_meta"].(map[string]interface{})["hostvars"].(map[string]interface{})
+		assert.NotContains(t, hostvars, "db1-slc")
+		assert.Contains(t, hostvars, "db2-lax")
+		assert.Equal(t, map[string]interface{}{"hosts": []string{"db2-lax"}}, inventory["function_db"])
+	})
+}
+
+func TestListHosts(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"devices/web1-slc.yaml": "a: 1\n",
+		"hosts.yaml":            "hosts: [db1-lax, printer]\n",
+	})
+	pattern := RegexPattern{Name: "host", Regex: "^(?P<Function>[a-z]+)\\d*-(?P<Datacenter>[a-z]+)$"}
+	snapshot := &Snapshot{Path: repo, Patterns: []RegexPattern{pattern}}
+
+	entries, err := ListHosts(snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, []HostEntry{
+		{Hostname: "db1-lax", Pattern: &pattern, Captures: map[string]string{"Function": "db", "Datacenter": "lax"}},
+		{Hostname: "printer", Captures: map[string]string{}, Orphan: true},
+		{Hostname: "web1-slc", Pattern: &pattern, Captures: map[string]string{"Function": "web", "Datacenter": "slc"}},
+	}, entries)
+
+	tests := []struct {
+		filters  map[string][]string
+		expected bool
+	}{
+		{filters: nil, expected: true},
+		{filters: map[string][]string{"Function": {"db"}}, expected: true},
+		{filters: map[string][]string{"Function": {"web", "db"}}, expected: true},
+		{filters: map[string][]string{"Function": {"db"}, "Datacenter": {"slc"}}, expected: false},
+		{filters: map[string][]string{"Role": {"db"}}, expected: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, entries[0].MatchesCaptures(tt.filters), "%v", tt.filters)
+	}
+
+	t.Run("computed once per snapshot", func(t *testing.T) {
+		writeFiles(t, repo, map[string]string{"devices/web2-slc.yaml": "a: 1\n"})
+		again, err := ListHosts(snapshot)
+		assert.NoError(t, err)
+		assert.Equal(t, entries, again)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := ListHosts(&Snapshot{Path: repo, Patterns: []RegexPattern{{Name: "broken", Regex: "(broken"}}})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,130 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// lookupConfig returns the config template function: it looks up a dotted
+// key in the values merged from the layers rendered before the current one
+// and fails if none of them defined it.
+func lookupConfig(config map[string]interface{}) func(string) (interface{}, error) {
+	return func(key string) (interface{}, error) {
+		value, ok := lookupPath(config, key)
+		if !ok {
+			return nil, fmt.Errorf("config key %s is not defined by an earlier layer", key)
+		}
+		return value, nil
+	}
+}
+
+// rewriteConfigRefs turns .Config.a.b and $.Config.a.b in the template into
+// config "a.b" calls. Layer templates run with missingkey=default, so a key
+// missing from .Config would otherwise render as "<no value>". A bare .Config
+// is left alone so it can still be passed to hasKey, get or range, and dot is
+// only rewritten where it still is the template data, not inside range or
+// with. Templates made with define or block are rewritten as well, they are
+// expected to be called with the template data.
+func rewriteConfigRefs(tmpl *template.Template) {
+	for _, associated := range tmpl.Templates() {
+		if associated.Tree != nil {
+			rewriteConfigNode(associated.Tree.Root, true)
+		}
+	}
+}
+
+func rewriteConfigNode(node parse.Node, dotIsData bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			rewriteConfigNode(child, dotIsData)
+		}
+	case *parse.ActionNode:
+		rewriteConfigPipe(n.Pipe, dotIsData)
+	case *parse.TemplateNode:
+		rewriteConfigPipe(n.Pipe, dotIsData)
+	case *parse.IfNode:
+		rewriteConfigPipe(n.Pipe, dotIsData)
+		rewriteConfigNode(n.List, dotIsData)
+		rewriteConfigNode(n.ElseList, dotIsData)
+	case *parse.RangeNode:
+		rewriteConfigPipe(n.Pipe, dotIsData)
+		rewriteConfigNode(n.List, false)
+		rewriteConfigNode(n.ElseList, dotIsData)
+	case *parse.WithNode:
+		rewriteConfigPipe(n.Pipe, dotIsData)
+		rewriteConfigNode(n.List, false)
+		rewriteConfigNode(n.ElseList, dotIsData)
+	}
+}
+
+func rewriteConfigPipe(pipe *parse.PipeNode, dotIsData bool) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for i, arg := range cmd.Args {
+			cmd.Args[i] = rewriteConfigArg(arg, dotIsData)
+		}
+	}
+}
+
+func rewriteConfigArg(arg parse.Node, dotIsData bool) parse.Node {
+	switch a := arg.(type) {
+	case *parse.FieldNode:
+		if dotIsData && len(a.Ident) > 1 && a.Ident[0] == "Config" {
+			return configCall(a.Pos, a.Ident[1:])
+		}
+	case *parse.VariableNode:
+		if len(a.Ident) > 2 && a.Ident[0] == "$" && a.Ident[1] == "Config" {
+			return configCall(a.Pos, a.Ident[2:])
+		}
+	case *parse.ChainNode:
+		a.Node = rewriteConfigArg(a.Node, dotIsData)
+	case *parse.PipeNode:
+		rewriteConfigPipe(a, dotIsData)
+	}
+	return arg
+}
+
+// configCall builds the parenthesized pipeline (config "a.b").
+func configCall(pos parse.Pos, keys []string) *parse.PipeNode {
+	key := strings.Join(keys, ".")
+	return &parse.PipeNode{
+		NodeType: parse.NodePipe,
+		Pos:      pos,
+		Cmds: []*parse.CommandNode{{
+			NodeType: parse.NodeCommand,
+			Pos:      pos,
+			Args: []parse.Node{
+				parse.NewIdentifier("config").SetPos(pos),
+				&parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(key), Text: key},
+			},
+		}},
+	}
+}
@@ -0,0 +1,91 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLayerConfig(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"all.yaml": "environment: prod\nnetwork:\n  prefix: 10.1.0.0/16\n",
+		"datacenters/slc.yaml": "network:\n  ip_range: {{ cidrsubnet .Config.network.prefix 8 1 }}\n" +
+			"motd: {{ .Config.environment }} in {{ .Datacenter }}\n",
+		"devices/web1-slc.yaml": "ip: {{ cidrhost $.Config.network.ip_range .Instance }}\n" +
+			"{{ if hasKey \"dns\" .Config }}dns: {{ .Config.dns }}{{ end }}\n" +
+			"seen:{{ range list \"environment\" \"motd\" }}\n  - {{ config . }}{{ end }}\n",
+		"devices/web2-slc.yaml": "vip: {{ .Config.network.vip }}\n",
+		"devices/web3-slc.yaml": "{{ with .Config.network }}prefix: {{ .prefix }}{{ end }}\n",
+		"devices/web4-slc.yaml": "{{ define \"env\" }}{{ .Config.environment }}{{ end }}env: {{ template \"env\" . }}\n",
+		"devices/web5-slc.yaml": "{{ define \"vip\" }}{{ .Config.network.vip }}{{ end }}vip: {{ template \"vip\" . }}\n" +
+			"{{ block \"gateway\" . }}gateway: {{ $.Config.network.gateway }}{{ end }}\n",
+	})
+	snapshot := &Snapshot{
+		Path:      repo,
+		Patterns:  []RegexPattern{{Name: "host", Regex: "^(?P<Function>[a-z]+)(?P<Instance>\\d+)-(?P<Datacenter>[a-z]+)$"}},
+		Hierarchy: DefaultHierarchy,
+	}
+
+	t.Run("layers see the values merged before them", func(t *testing.T) {
+		_, data, err := RenderHost(snapshot, "web1-slc")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"environment": "prod",
+			"network":     map[string]interface{}{"prefix": "10.1.0.0/16", "ip_range": "10.1.1.0/24"},
+			"motd":        "prod in slc",
+			"ip":          "10.1.1.1",
+			"seen":        []interface{}{"prod", "prod in slc"},
+		}, data)
+	})
+
+	t.Run("undefined key", func(t *testing.T) {
+		_, _, err := RenderHost(snapshot, "web2-slc")
+		var layerErr *LayerError
+		assert.True(t, errors.As(err, &layerErr), "expected a LayerError, got %v", err)
+		if layerErr != nil {
+			assert.Equal(t, "devices/web2-slc.yaml", layerErr.Path)
+		}
+		assert.ErrorContains(t, err, "config:1:")
+		assert.ErrorContains(t, err, "config key network.vip is not defined by an earlier layer")
+	})
+
+	t.Run("dot inside with is not the template data", func(t *testing.T) {
+		_, data, err := RenderHost(snapshot, "web3-slc")
+		assert.NoError(t, err)
+		assert.Equal(t, "10.1.0.0/16", data["prefix"])
+	})
+
+	t.Run("defined templates", func(t *testing.T) {
+		_, data, err := RenderHost(snapshot, "web4-slc")
+		assert.NoError(t, err)
+		assert.Equal(t, "prod", data["env"])
+
+		_, _, err = RenderHost(snapshot, "web5-slc")
+		assert.ErrorContains(t, err, "config key network.vip is not defined by an earlier layer")
+	})
+
+	t.Run("config without a snapshot is empty", func(t *testing.T) {
+		_, err := lookupConfig(nil)("environment")
+		assert.Error(t, err)
+	})
+}
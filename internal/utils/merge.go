@@ -0,0 +1,115 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+// DeepMerge merges the src layer into dst and returns the result.
+//
+// The merge walks src key by key:
+//   - a key that only exists in src is copied into dst
+//   - when the key holds a map in both dst and src, the two maps are merged
+//     recursively using these same rules
+//   - in every other case (scalars, lists or mismatched types) the src value
+//     replaces the dst value
+//
+// A src value wrapped in a MergeDirective overrides these rules for its key:
+//   - MergeDelete removes the key from dst
+//   - MergeReplace stores the value as is, without merging it into a map
+//   - MergeAppend and MergePrepend add the list items after or before the
+//     inherited list; without an inherited list they behave like MergeReplace
+//
+// Maps coming from src are copied, never shared, so merging further layers
+// into the result can not modify a layer that was merged earlier. Directives
+// are always resolved, so the result never contains a MergeDirective. A nil
+// dst is treated as an empty map.
+func DeepMerge(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{}, len(src))
+	}
+
+	for key, srcValue := range src {
+		if directive, ok := srcValue.(MergeDirective); ok {
+			applyDirective(dst, key, directive)
+			continue
+		}
+
+		srcMap, srcIsMap := srcValue.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+
+		if srcIsMap && dstIsMap {
+			dst[key] = DeepMerge(dstMap, srcMap)
+		} else {
+			dst[key] = resolveValue(srcValue)
+		}
+	}
+
+	return dst
+}
+
+// applyDirective stores the result of a merge directive under key in dst.
+func applyDirective(dst map[string]interface{}, key string, directive MergeDirective) {
+	value := resolveValue(directive.Value)
+
+	switch directive.Op {
+	case MergeDelete:
+		delete(dst, key)
+		return
+	case MergeAppend, MergePrepend:
+		inherited, ok := dst[key].([]interface{})
+		if !ok {
+			break
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+
+		combined := make([]interface{}, 0, len(inherited)+len(items))
+		if directive.Op == MergeAppend {
+			combined = append(append(combined, inherited...), items...)
+		} else {
+			combined = append(append(combined, items...), inherited...)
+		}
+		dst[key] = combined
+		return
+	}
+
+	dst[key] = value
+}
+
+// resolveValue copies a value from a layer that has nothing to be merged
+// into, resolving any directives nested inside it.
+func resolveValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case MergeDirective:
+		return resolveValue(typed.Value)
+	case map[string]interface{}:
+		return DeepMerge(nil, typed)
+	case []interface{}:
+		list := make([]interface{}, 0, len(typed))
+		for _, item := range typed {
+			if directive, ok := item.(MergeDirective); ok && directive.Op == MergeDelete {
+				continue
+			}
+			list = append(list, resolveValue(item))
+		}
+		return list
+	}
+	return value
+}
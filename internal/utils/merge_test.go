@@ -0,0 +1,184 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDeepMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      map[string]interface{}
+		src      map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "new keys are added",
+			dst:      map[string]interface{}{"a": 1},
+			src:      map[string]interface{}{"b": 2},
+			expected: map[string]interface{}{"a": 1, "b": 2},
+		},
+		{
+			name:     "scalars are replaced",
+			dst:      map[string]interface{}{"a": 1},
+			src:      map[string]interface{}{"a": 2},
+			expected: map[string]interface{}{"a": 2},
+		},
+		{
+			name: "nested maps override single leaves",
+			dst: map[string]interface{}{
+				"contact": map[string]interface{}{
+					"phone_number": "555-555-0000",
+					"support":      "support@example.com",
+				},
+			},
+			src: map[string]interface{}{
+				"contact": map[string]interface{}{
+					"phone_number": "555-555-1234",
+				},
+			},
+			expected: map[string]interface{}{
+				"contact": map[string]interface{}{
+					"phone_number": "555-555-1234",
+					"support":      "support@example.com",
+				},
+			},
+		},
+		{
+			name: "deeply nested maps are merged",
+			dst: map[string]interface{}{
+				"a": map[string]interface{}{"b": map[string]interface{}{"c": 1, "d": 2}},
+			},
+			src: map[string]interface{}{
+				"a": map[string]interface{}{"b": map[string]interface{}{"d": 3}},
+			},
+			expected: map[string]interface{}{
+				"a": map[string]interface{}{"b": map[string]interface{}{"c": 1, "d": 3}},
+			},
+		},
+		{
+			name:     "lists are replaced",
+			dst:      map[string]interface{}{"ports": []interface{}{8100, 8101}},
+			src:      map[string]interface{}{"ports": []interface{}{8102}},
+			expected: map[string]interface{}{"ports": []interface{}{8102}},
+		},
+		{
+			name:     "map replaces scalar",
+			dst:      map[string]interface{}{"a": "scalar"},
+			src:      map[string]interface{}{"a": map[string]interface{}{"b": 1}},
+			expected: map[string]interface{}{"a": map[string]interface{}{"b": 1}},
+		},
+		{
+			name:     "scalar replaces map",
+			dst:      map[string]interface{}{"a": map[string]interface{}{"b": 1}},
+			src:      map[string]interface{}{"a": "scalar"},
+			expected: map[string]interface{}{"a": "scalar"},
+		},
+		{
+			name:     "nil destination",
+			dst:      nil,
+			src:      map[string]interface{}{"a": 1},
+			expected: map[string]interface{}{"a": 1},
+		},
+		{
+			name:     "append to inherited list",
+			dst:      map[string]interface{}{"ports": []interface{}{8100, 8101}},
+			src:      map[string]interface{}{"ports": MergeDirective{Op: MergeAppend, Value: []interface{}{8102}}},
+			expected: map[string]interface{}{"ports": []interface{}{8100, 8101, 8102}},
+		},
+		{
+			name:     "prepend to inherited list",
+			dst:      map[string]interface{}{"ntp_servers": []interface{}{"ntp2"}},
+			src:      map[string]interface{}{"ntp_servers": MergeDirective{Op: MergePrepend, Value: []interface{}{"ntp1"}}},
+			expected: map[string]interface{}{"ntp_servers": []interface{}{"ntp1", "ntp2"}},
+		},
+		{
+			name:     "append a single item",
+			dst:      map[string]interface{}{"ports": []interface{}{8100}},
+			src:      map[string]interface{}{"ports": MergeDirective{Op: MergeAppend, Value: 8101}},
+			expected: map[string]interface{}{"ports": []interface{}{8100, 8101}},
+		},
+		{
+			name:     "append without inherited list",
+			dst:      map[string]interface{}{},
+			src:      map[string]interface{}{"ports": MergeDirective{Op: MergeAppend, Value: []interface{}{8102}}},
+			expected: map[string]interface{}{"ports": []interface{}{8102}},
+		},
+		{
+			name: "replace a map instead of merging it",
+			dst: map[string]interface{}{
+				"features": map[string]interface{}{"vpn": true, "firewall": true},
+			},
+			src: map[string]interface{}{
+				"features": MergeDirective{Op: MergeReplace, Value: map[string]interface{}{"vpn": false}},
+			},
+			expected: map[string]interface{}{
+				"features": map[string]interface{}{"vpn": false},
+			},
+		},
+		{
+			name:     "delete an inherited key",
+			dst:      map[string]interface{}{"a": 1, "b": 2},
+			src:      map[string]interface{}{"a": MergeDirective{Op: MergeDelete}},
+			expected: map[string]interface{}{"b": 2},
+		},
+		{
+			name: "delete a nested key",
+			dst: map[string]interface{}{
+				"contact": map[string]interface{}{"phone_number": "555", "support": "support@example.com"},
+			},
+			src: map[string]interface{}{
+				"contact": map[string]interface{}{"phone_number": MergeDirective{Op: MergeDelete}},
+			},
+			expected: map[string]interface{}{
+				"contact": map[string]interface{}{"support": "support@example.com"},
+			},
+		},
+		{
+			name: "directives nested in new keys are resolved",
+			dst:  map[string]interface{}{},
+			src: map[string]interface{}{
+				"a": map[string]interface{}{
+					"b": MergeDirective{Op: MergeDelete},
+					"c": MergeDirective{Op: MergeAppend, Value: []interface{}{1}},
+				},
+			},
+			expected: map[string]interface{}{
+				"a": map[string]interface{}{"c": []interface{}{1}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DeepMerge(tt.dst, tt.src))
+		})
+	}
+
+	t.Run("source maps are not shared with the result", func(t *testing.T) {
+		src := map[string]interface{}{"a": map[string]interface{}{"b": 1}}
+		merged := DeepMerge(nil, src)
+		DeepMerge(merged, map[string]interface{}{"a": map[string]interface{}{"b": 2}})
+
+		assert.Equal(t, 1, src["a"].(map[string]interface{})["b"])
+	})
+}
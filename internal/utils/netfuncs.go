@@ -0,0 +1,169 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+)
+
+// The network functions follow the Terraform functions of the same name.
+// Addresses may be IPv4 or IPv6, numbers may be numeric strings so capture
+// groups can be used directly: {{ cidrhost "10.0.0.0/24" .Instance }}.
+
+// cidrhost returns the address of host number hostnum in the prefix.
+// Negative numbers count from the end, -1 is the last address.
+func cidrhost(prefix string, hostnum interface{}) (string, error) {
+	p, err := parsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	n, err := toInt(hostnum)
+	if err != nil {
+		return "", err
+	}
+
+	hostBits := p.Addr().BitLen() - p.Bits()
+	count := new(big.Int).Lsh(big.NewInt(1), uint(hostBits))
+	offset := big.NewInt(int64(n))
+	if n < 0 {
+		offset.Add(offset, count)
+	}
+	if offset.Sign() < 0 || offset.Cmp(count) >= 0 {
+		return "", fmt.Errorf("cidrhost: prefix %s has no host number %d", prefix, n)
+	}
+
+	addr, err := addToAddr(p.Addr(), offset)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// cidrsubnet returns subnet netnum of the prefix extended by newbits:
+// cidrsubnet "10.0.0.0/16" 8 2 is 10.0.2.0/24.
+func cidrsubnet(prefix string, newbits, netnum interface{}) (string, error) {
+	p, err := parsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	bits, err := toInt(newbits)
+	if err != nil {
+		return "", err
+	}
+	n, err := toInt(netnum)
+	if err != nil {
+		return "", err
+	}
+
+	length := p.Bits() + bits
+	if bits < 0 || length > p.Addr().BitLen() {
+		return "", fmt.Errorf("cidrsubnet: cannot extend prefix %s by %d bits", prefix, bits)
+	}
+	if n < 0 || big.NewInt(int64(n)).Cmp(new(big.Int).Lsh(big.NewInt(1), uint(bits))) >= 0 {
+		return "", fmt.Errorf("cidrsubnet: prefix %s has no subnet number %d with %d new bits", prefix, n, bits)
+	}
+
+	offset := new(big.Int).Lsh(big.NewInt(int64(n)), uint(p.Addr().BitLen()-length))
+	addr, err := addToAddr(p.Addr(), offset)
+	if err != nil {
+		return "", err
+	}
+	return netip.PrefixFrom(addr, length).String(), nil
+}
+
+// cidrnetmask returns the netmask of an IPv4 prefix: 255.255.255.0 for a /24.
+func cidrnetmask(prefix string) (string, error) {
+	p, err := parsePrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	if !p.Addr().Is4() {
+		return "", fmt.Errorf("cidrnetmask: %s is not an IPv4 prefix", prefix)
+	}
+	return net.IP(net.CIDRMask(p.Bits(), 32)).String(), nil
+}
+
+// cidrprefixlen returns the prefix length: 24 for 10.0.0.0/24.
+func cidrprefixlen(prefix string) (int, error) {
+	p, err := parsePrefix(prefix)
+	if err != nil {
+		return 0, err
+	}
+	return p.Bits(), nil
+}
+
+// cidrcontains reports whether the prefix contains the address.
+func cidrcontains(prefix, ip string) (bool, error) {
+	p, err := parsePrefix(prefix)
+	if err != nil {
+		return false, err
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, err
+	}
+	return p.Contains(addr), nil
+}
+
+// ipadd adds offset, which may be negative, to an address:
+// ipadd "10.0.0.10" 5 is 10.0.0.15.
+func ipadd(ip string, offset interface{}) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	n, err := toInt(offset)
+	if err != nil {
+		return "", err
+	}
+	result, err := addToAddr(addr, big.NewInt(int64(n)))
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// parsePrefix parses a prefix and drops the host bits, so 10.0.0.5/24 is
+// treated as 10.0.0.0/24.
+func parsePrefix(prefix string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
+
+// addToAddr adds offset to addr, failing if the result leaves the address
+// family.
+func addToAddr(addr netip.Addr, offset *big.Int) (netip.Addr, error) {
+	addr = addr.Unmap()
+	n := new(big.Int).SetBytes(addr.AsSlice())
+	n.Add(n, offset)
+
+	size := addr.BitLen() / 8
+	if n.Sign() < 0 || n.BitLen() > addr.BitLen() {
+		return netip.Addr{}, fmt.Errorf("%s%+d is not a valid address", addr, offset)
+	}
+	result, _ := netip.AddrFromSlice(n.FillBytes(make([]byte, size)))
+	return result, nil
+}
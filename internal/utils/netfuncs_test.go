@@ -0,0 +1,80 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNetFuncs(t *testing.T) {
+	data := map[string]string{"Instance": "7", "ip_range": "192.168.0.0/24"}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"cidrhost", `{{ cidrhost .ip_range .Instance }}`, "192.168.0.7"},
+		{"cidrhost from the end", `{{ cidrhost "10.0.0.0/24" -1 }} {{ cidrhost "10.0.0.0/24" -256 }}`, "10.0.0.255 10.0.0.0"},
+		{"cidrhost ignores host bits", `{{ cidrhost "10.0.0.77/24" 5 }}`, "10.0.0.5"},
+		{"cidrhost ipv6", `{{ cidrhost "fd00:10::/64" 258 }}`, "fd00:10::102"},
+		{"cidrsubnet", `{{ cidrsubnet "10.0.0.0/16" 8 2 }} {{ cidrsubnet "10.0.0.0/16" 0 0 }}`, "10.0.2.0/24 10.0.0.0/16"},
+		{"cidrsubnet ipv6", `{{ cidrsubnet "fd00::/48" 16 10 }}`, "fd00:0:0:a::/64"},
+		{"nested", `{{ cidrhost (cidrsubnet "10.1.0.0/16" 8 .Instance) 10 }}`, "10.1.7.10"},
+		{"cidrnetmask", `{{ cidrnetmask "10.0.0.0/20" }} {{ cidrnetmask "0.0.0.0/0" }}`, "255.255.240.0 0.0.0.0"},
+		{"cidrprefixlen", `{{ cidrprefixlen .ip_range }} {{ cidrprefixlen "fd00::/56" }}`, "24 56"},
+		{"cidrcontains", `{{ cidrcontains .ip_range "192.168.0.9" }} {{ cidrcontains .ip_range "192.168.1.9" }}`, "true false"},
+		{"ipadd", `{{ ipadd "10.0.0.250" 10 }} {{ ipadd "10.0.1.0" -1 }} {{ ipadd "fd00::ffff" 1 }}`, "10.0.1.4 10.0.0.255 fd00::1:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := render(t, tt.template, data)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestNetFuncErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"invalid prefix", `{{ cidrhost "10.0.0.0" 1 }}`},
+		{"host number too large", `{{ cidrhost "10.0.0.0/30" 4 }}`},
+		{"host number too small", `{{ cidrhost "10.0.0.0/30" -5 }}`},
+		{"subnet too long", `{{ cidrsubnet "10.0.0.0/24" 9 0 }}`},
+		{"subnet number too large", `{{ cidrsubnet "10.0.0.0/16" 2 4 }}`},
+		{"netmask of ipv6", `{{ cidrnetmask "fd00::/64" }}`},
+		{"address overflow", `{{ ipadd "255.255.255.255" 1 }}`},
+		{"address underflow", `{{ ipadd "0.0.0.0" -1 }}`},
+		{"invalid address", `{{ ipadd "10.0.0" 1 }}`},
+		{"non-numeric offset", `{{ ipadd "10.0.0.1" "web" }}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := render(t, tt.template, nil)
+			assert.Error(t, err)
+		})
+	}
+}
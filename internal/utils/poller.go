@@ -0,0 +1,128 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// PollSchedule decides how long a Repo waits between fetches.
+type PollSchedule struct {
+	Interval time.Duration // Wait after a successful fetch
+	Jitter   time.Duration // Random extra wait, spreads replicas apart
+	// After a failed fetch the wait starts at FailureBackoff and doubles
+	// with every consecutive failure, up to MaxBackoff (Interval if unset)
+	FailureBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// RepoStatus reports the health of the fetches of a Repo.
+type RepoStatus struct {
+	Branch              string    `json:"branch"`
+	Commit              string    `json:"commit"`
+	LastFetch           time.Time `json:"last_fetch"` // Last successful fetch
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// next returns the wait before the next fetch.
+func (p PollSchedule) next(failures int) time.Duration {
+	wait := p.Interval
+	if failures > 0 && p.FailureBackoff > 0 {
+		maxBackoff := p.MaxBackoff
+		if maxBackoff <= 0 {
+			maxBackoff = p.Interval
+		}
+
+		wait = p.FailureBackoff
+		for i := 1; i < failures && wait < maxBackoff; i++ {
+			wait *= 2
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(p.Jitter)))
+	}
+	return wait
+}
+
+// Watch refreshes the repo following the schedule, or earlier when
+// TriggerRefresh asks for it. It never returns.
+func (r *Repo) Watch(schedule PollSchedule) {
+	timer := time.NewTimer(schedule.next(r.Status().ConsecutiveFailures))
+	defer timer.Stop()
+
+	for {
+		// Wait for the next fetch or an explicit trigger
+		select {
+		case <-timer.C:
+		case <-r.trigger:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+		if err := r.Refresh(); err != nil {
+			logRefreshError(err)
+		}
+		timer.Reset(schedule.next(r.Status().ConsecutiveFailures))
+	}
+}
+
+// Status returns the fetch status of the repo.
+func (r *Repo) Status() RepoStatus {
+	r.statusMutex.Lock()
+	status := r.status
+	r.statusMutex.Unlock()
+
+	status.Branch = r.Branch
+	if current := r.Snapshots.Current(); current != nil {
+		status.Commit = current.Commit
+	}
+	return status
+}
+
+func (r *Repo) recordFetch(err error) {
+	r.statusMutex.Lock()
+	defer r.statusMutex.Unlock()
+
+	if err != nil {
+		r.status.ConsecutiveFailures++
+		r.status.LastError = err.Error()
+		return
+	}
+	r.status.ConsecutiveFailures = 0
+	r.status.LastError = ""
+	r.status.LastFetch = time.Now()
+}
+
+// WatchedRepoStatus returns the status of every watched repo.
+func WatchedRepoStatus() []RepoStatus {
+	watchedReposMutex.Lock()
+	defer watchedReposMutex.Unlock()
+
+	statuses := make([]RepoStatus, 0, len(watchedRepos))
+	for _, r := range watchedRepos {
+		statuses = append(statuses, r.Status())
+	}
+	return statuses
+}
@@ -0,0 +1,110 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPollScheduleNext(t *testing.T) {
+	schedule := PollSchedule{
+		Interval:       20 * time.Minute,
+		FailureBackoff: 30 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 0, expected: 20 * time.Minute},
+		{failures: 1, expected: 30 * time.Second},
+		{failures: 2, expected: time.Minute},
+		{failures: 3, expected: 2 * time.Minute},
+		{failures: 4, expected: 4 * time.Minute},
+		{failures: 5, expected: 5 * time.Minute},
+		{failures: 100, expected: 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, schedule.next(tt.failures), "failures: %d", tt.failures)
+	}
+
+	t.Run("backoff is capped at the interval by default", func(t *testing.T) {
+		schedule := PollSchedule{Interval: time.Minute, FailureBackoff: 30 * time.Second}
+		assert.Equal(t, time.Minute, schedule.next(10))
+	})
+
+	t.Run("no backoff configured", func(t *testing.T) {
+		schedule := PollSchedule{Interval: time.Minute}
+		assert.Equal(t, time.Minute, schedule.next(3))
+	})
+
+	t.Run("jitter stays within range", func(t *testing.T) {
+		schedule := PollSchedule{Interval: time.Minute, Jitter: 10 * time.Second}
+		for i := 0; i < 100; i++ {
+			wait := schedule.next(0)
+			assert.GreaterOrEqual(t, wait, time.Minute)
+			assert.Less(t, wait, time.Minute+10*time.Second)
+		}
+	})
+}
+
+func TestRepoStatus(t *testing.T) {
+	source := newTestRepo(t, "main")
+	commit := source.commit(map[string]string{
+		"domains_regex.yaml": testDomainsRegex,
+		"all.yaml":           "version: 1\n",
+	})
+
+	var store SnapshotStore
+	repo := NewRepo(source.path, "main", t.TempDir(), &store)
+	repo.FetchTimeout = 10 * time.Second
+	t.Cleanup(func() { removeReadOnlyTree(repo.Dir) })
+
+	assert.NoError(t, repo.Clone())
+	status := repo.Status()
+	assert.Equal(t, "main", status.Branch)
+	assert.Equal(t, commit, status.Commit)
+	assert.Zero(t, status.ConsecutiveFailures)
+	assert.WithinDuration(t, time.Now(), status.LastFetch, 5*time.Second)
+	lastFetch := status.LastFetch
+
+	// Make the remote unreachable
+	hidden := source.path + ".hidden"
+	assert.NoError(t, os.Rename(source.path, hidden))
+	assert.Error(t, repo.Refresh())
+	assert.Error(t, repo.Refresh())
+
+	status = repo.Status()
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.NotEmpty(t, status.LastError)
+	assert.Equal(t, lastFetch, status.LastFetch)
+	assert.Equal(t, commit, status.Commit)
+
+	assert.NoError(t, os.Rename(hidden, source.path))
+	assert.NoError(t, repo.Refresh())
+	status = repo.Status()
+	assert.Zero(t, status.ConsecutiveFailures)
+	assert.Empty(t, status.LastError)
+	assert.True(t, status.LastFetch.After(lastFetch))
+}
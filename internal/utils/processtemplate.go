@@ -21,29 +21,68 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/yaml.v3"
 	"os"
+	"regexp"
 	"text/template"
 )
 
+// ProcessTemplate renders a layer file without a snapshot, so cross-host
+// lookups fail and .Config is empty. ResolveLayers renders the layers of a
+// snapshot.
 func ProcessTemplate(filePath string, data map[string]string) (map[string]interface{}, error) {
+	logTemplateData(data, SensitiveRules{})
+	return processTemplate(filePath, data, nil, templateFuncs(nil), false)
+}
+
+// logTemplateData logs the capture groups templates are rendered with,
+// masking the sensitive ones.
+func logTemplateData(captures map[string]string, rules SensitiveRules) {
+	if zerolog.GlobalLevel() != zerolog.DebugLevel {
+		return
+	}
+	for key, value := range captures {
+		if rules.Sensitive(key) {
+			value = RedactedValue
+		}
+		log.Debug().Str(key, value).Msg("Template data map")
+	}
+}
+
+// processTemplate renders a layer file with the capture groups and, as
+// .Config, the values merged from the layers before it. funcs must provide
+// the config function for the same values. Strict templates, and templates
+// starting with {{/* strict */}}, fail on missing map keys instead of
+// rendering "<no value>".
+func processTemplate(filePath string, captures map[string]string, config map[string]interface{}, funcs template.FuncMap, strict bool) (map[string]interface{}, error) {
 	fileContent, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
-
-	if zerolog.GlobalLevel() == zerolog.DebugLevel {
-		for key, value := range data {
-			log.Debug().Str(key, value).Msg("Template data map")
-		}
+	fileContent, err = GlobalSecretKeys.decryptFile(fileContent)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted file: %w", err)
 	}
 
-	tmpl, err := template.New("config").Parse(string(fileContent))
+	tmpl, err := parseTemplate(string(fileContent), funcs)
 	if err != nil {
 		return nil, err
 	}
+	rewriteConfigRefs(tmpl)
+	if strict || strictTemplate.Match(fileContent) {
+		tmpl.Option("missingkey=error")
+	}
+
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	data := make(map[string]interface{}, len(captures)+1)
+	for key, value := range captures {
+		data[key] = value
+	}
+	data["Config"] = config
 
 	// Create a buffer to store the output
 	var output bytes.Buffer
@@ -56,11 +95,25 @@ func ProcessTemplate(filePath string, data map[string]string) (map[string]interf
 
 	// Assuming that the template generates YAML content,
 	// we need to Unmarshal it into a map for further use.
-	var yamlMap map[string]interface{}
-	err = yaml.Unmarshal(output.Bytes(), &yamlMap)
+	// Merge directives are kept so DeepMerge can apply them.
+	yamlMap, err := decodeYAML(output.Bytes())
 	if err != nil {
 		return nil, err
 	}
+	if _, err := GlobalSecretKeys.decryptValues("", yamlMap); err != nil {
+		return nil, err
+	}
 
 	return yamlMap, nil
 }
+
+// strictTemplate matches the comment that opts a single layer file into
+// strict mode.
+var strictTemplate = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*strict\s*\*/\s*-?\}\}`)
+
+// parseTemplate parses the content of a layer file the same way for
+// rendering and for validation. Validation only needs the names of the
+// functions, templateFuncs(nil) provides them.
+func parseTemplate(content string, funcs template.FuncMap) (*template.Template, error) {
+	return template.New("config").Funcs(funcs).Parse(content)
+}
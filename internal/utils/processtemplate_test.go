@@ -24,6 +24,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -105,3 +106,30 @@ func TestProcessTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessTemplateStrict(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"lenient.yaml": "function: web\ndatacenter: {{ .Datacenter }}\n",
+		"marked.yaml":  "{{/* strict */}}\nfunction: web\ndatacenter: {{ .Datacenter }}\n",
+	})
+	captures := map[string]string{"Function": "web"}
+
+	t.Run("missing keys render as no value", func(t *testing.T) {
+		data, err := processTemplate(filepath.Join(dir, "lenient.yaml"), captures, nil, templateFuncs(nil), false)
+		assert.NoError(t, err)
+		assert.Equal(t, "<no value>", data["datacenter"])
+	})
+
+	t.Run("strict mode names the line and key", func(t *testing.T) {
+		_, err := processTemplate(filepath.Join(dir, "lenient.yaml"), captures, nil, templateFuncs(nil), true)
+		assert.ErrorContains(t, err, "config:2:")
+		assert.ErrorContains(t, err, `map has no entry for key "Datacenter"`)
+	})
+
+	t.Run("templates can opt in", func(t *testing.T) {
+		_, err := processTemplate(filepath.Join(dir, "marked.yaml"), captures, nil, templateFuncs(nil), false)
+		assert.ErrorContains(t, err, "config:3:")
+		assert.ErrorContains(t, err, `map has no entry for key "Datacenter"`)
+	})
+}
@@ -0,0 +1,164 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+)
+
+// PuppetMapping is the enc section of puppet.yaml. It names the keys of the
+// merged configuration that hold the classes, parameters and environment
+// of a Puppet node. Keys are dotted paths into the configuration.
+type PuppetMapping struct {
+	Classes     string `yaml:"classes"`
+	Environment string `yaml:"environment"`
+	// Parameters names a map of parameters. When empty, every key that is
+	// not used for the classes or the environment becomes a parameter.
+	Parameters         string `yaml:"parameters"`
+	DefaultEnvironment string `yaml:"default_environment"`
+}
+
+type puppetConfig struct {
+	ENC PuppetMapping `yaml:"enc"`
+}
+
+// DefaultPuppetMapping is used when the config repo has no puppet.yaml.
+var DefaultPuppetMapping = PuppetMapping{Classes: "classes", Environment: "puppet_environment"}
+
+// PuppetNode is the YAML document a Puppet external node classifier returns.
+// Classes is either a list of class names or a map of class parameters.
+type PuppetNode struct {
+	Classes     interface{}            `yaml:"classes"`
+	Parameters  map[string]interface{} `yaml:"parameters"`
+	Environment string                 `yaml:"environment,omitempty"`
+}
+
+// ReadPuppetMapping reads the enc section of a YAML file, falling back to
+// DefaultPuppetMapping when the file does not exist
+func ReadPuppetMapping(filePath string) (PuppetMapping, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultPuppetMapping, nil
+	}
+	if err != nil {
+		return PuppetMapping{}, err
+	}
+
+	var config puppetConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return PuppetMapping{}, err
+	}
+	if config.ENC.Classes == "" {
+		return PuppetMapping{}, errors.New("puppet.yaml: enc.classes is required")
+	}
+	return config.ENC, nil
+}
+
+// Classify builds the Puppet node of a host from its merged configuration.
+func (m PuppetMapping) Classify(data map[string]interface{}) (*PuppetNode, error) {
+	node := &PuppetNode{Classes: []string{}, Environment: m.DefaultEnvironment}
+
+	if value, ok := lookupPath(data, m.Classes); ok && value != nil {
+		switch classes := value.(type) {
+		case []interface{}:
+			for _, class := range classes {
+				if _, ok := class.(string); !ok {
+					return nil, fmt.Errorf("%s: class names must be strings, got %v", m.Classes, class)
+				}
+			}
+		case map[string]interface{}:
+		default:
+			return nil, fmt.Errorf("%s: classes must be a list or a map", m.Classes)
+		}
+		node.Classes = value
+	}
+
+	if value, ok := lookupPath(data, m.Environment); ok && value != nil {
+		environment, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: environment must be a string", m.Environment)
+		}
+		node.Environment = environment
+	}
+
+	if m.Parameters == "" {
+		node.Parameters = withoutPaths(data, m.Classes, m.Environment)
+		return node, nil
+	}
+	node.Parameters = map[string]interface{}{}
+	if value, ok := lookupPath(data, m.Parameters); ok && value != nil {
+		parameters, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: parameters must be a map", m.Parameters)
+		}
+		node.Parameters = parameters
+	}
+	return node, nil
+}
+
+// lookupPath returns the value at a dotted path of a merged configuration.
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		nested, ok := data[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		data = nested
+	}
+	value, ok := data[keys[len(keys)-1]]
+	return value, ok
+}
+
+// withoutPaths returns a copy of data without the values at the dotted
+// paths, dropping maps that end up empty. Maps along the paths are copied,
+// data itself is not modified.
+func withoutPaths(data map[string]interface{}, paths ...string) map[string]interface{} {
+	result := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		key, rest, nested := strings.Cut(path, ".")
+		if !nested {
+			delete(result, key)
+			continue
+		}
+		if child, ok := result[key].(map[string]interface{}); ok {
+			// Don't leave a map behind that only held the removed values
+			if child = withoutPaths(child, rest); len(child) > 0 {
+				result[key] = child
+			} else {
+				delete(result, key)
+			}
+		}
+	}
+	return result
+}
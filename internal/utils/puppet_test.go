@@ -0,0 +1,163 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPuppetMapping(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expected    PuppetMapping
+		expectedErr bool
+	}{
+		{
+			name:     "missing file",
+			expected: DefaultPuppetMapping,
+		},
+		{
+			name:     "configured mapping",
+			content:  "enc:\n  classes: puppet.classes\n  environment: puppet.environment\n  parameters: puppet.parameters\n  default_environment: production\n",
+			expected: PuppetMapping{Classes: "puppet.classes", Environment: "puppet.environment", Parameters: "puppet.parameters", DefaultEnvironment: "production"},
+		},
+		{
+			name:        "classes missing",
+			content:     "enc:\n  environment: env\n",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid yaml",
+			content:     "enc: [\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.content != "" {
+				writeFiles(t, dir, map[string]string{"puppet.yaml": tt.content})
+			}
+
+			mapping, err := ReadPuppetMapping(filepath.Join(dir, "puppet.yaml"))
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, mapping)
+		})
+	}
+}
+
+func TestPuppetClassify(t *testing.T) {
+	tests := []struct {
+		name        string
+		mapping     PuppetMapping
+		data        map[string]interface{}
+		expected    *PuppetNode
+		expectedErr bool
+	}{
+		{
+			name:    "default mapping",
+			mapping: DefaultPuppetMapping,
+			data: map[string]interface{}{
+				"classes":            []interface{}{"ntp", "postgresql::server"},
+				"puppet_environment": "production",
+				"owner":              "superappteam",
+			},
+			expected: &PuppetNode{
+				Classes:     []interface{}{"ntp", "postgresql::server"},
+				Parameters:  map[string]interface{}{"owner": "superappteam"},
+				Environment: "production",
+			},
+		},
+		{
+			name:    "nested keys and class parameters",
+			mapping: PuppetMapping{Classes: "puppet.classes", Environment: "puppet.environment"},
+			data: map[string]interface{}{
+				"puppet": map[string]interface{}{
+					"classes":     map[string]interface{}{"ntp": map[string]interface{}{"servers": []interface{}{"ntp1"}}},
+					"environment": "staging",
+					"role":        "db",
+				},
+				"owner": "superappteam",
+			},
+			expected: &PuppetNode{
+				Classes:     map[string]interface{}{"ntp": map[string]interface{}{"servers": []interface{}{"ntp1"}}},
+				Parameters:  map[string]interface{}{"puppet": map[string]interface{}{"role": "db"}, "owner": "superappteam"},
+				Environment: "staging",
+			},
+		},
+		{
+			name:    "parameters key and default environment",
+			mapping: PuppetMapping{Classes: "classes", Parameters: "params", DefaultEnvironment: "production"},
+			data:    map[string]interface{}{"params": map[string]interface{}{"a": 1}, "owner": "superappteam"},
+			expected: &PuppetNode{
+				Classes:     []string{},
+				Parameters:  map[string]interface{}{"a": 1},
+				Environment: "production",
+			},
+		},
+		{
+			name:        "classes not a list",
+			mapping:     DefaultPuppetMapping,
+			data:        map[string]interface{}{"classes": "ntp"},
+			expectedErr: true,
+		},
+		{
+			name:        "class name not a string",
+			mapping:     DefaultPuppetMapping,
+			data:        map[string]interface{}{"classes": []interface{}{1}},
+			expectedErr: true,
+		},
+		{
+			name:        "environment not a string",
+			mapping:     DefaultPuppetMapping,
+			data:        map[string]interface{}{"puppet_environment": []interface{}{"a"}},
+			expectedErr: true,
+		},
+		{
+			name:        "parameters not a map",
+			mapping:     PuppetMapping{Classes: "classes", Parameters: "params"},
+			data:        map[string]interface{}{"params": "a"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := DeepMerge(map[string]interface{}{}, tt.data)
+
+			node, err := tt.mapping.Classify(tt.data)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, node)
+			assert.Equal(t, original, tt.data, "data was modified")
+		})
+	}
+}
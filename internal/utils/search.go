@@ -0,0 +1,223 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SelectorOp is the comparison a selector requirement makes.
+type SelectorOp string
+
+const (
+	SelectEquals    SelectorOp = "="
+	SelectNotEquals SelectorOp = "!="
+	SelectMatches   SelectorOp = "~="
+	SelectExists    SelectorOp = "exists"
+	SelectNotExists SelectorOp = "!exists"
+)
+
+// Requirement is a single term of a selector, e.g. features.vpn=true.
+type Requirement struct {
+	Path  string // Dotted path into the merged configuration
+	Op    SelectorOp
+	Value string
+	re    *regexp.Regexp
+}
+
+// Selector matches a merged configuration when all of its requirements do.
+type Selector []Requirement
+
+// ParseSelector parses a comma separated list of requirements:
+//
+//	key=value, key==value  the value at key equals value
+//	key!=value             key is missing or its value differs
+//	key~=regex             the value at key matches the regular expression
+//	key                    key exists
+//	!key                   key does not exist
+//
+// Values are compared in their string form. A list matches =, != and ~=
+// through its items, ports=8102 matches a list that contains 8102.
+func ParseSelector(selector string) (Selector, error) {
+	var parsed Selector
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		requirement, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, requirement)
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("empty selector")
+	}
+	return parsed, nil
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	var requirement Requirement
+	switch i := strings.IndexAny(term, "!~="); {
+	case i < 0:
+		requirement = Requirement{Path: term, Op: SelectExists}
+	case i == 0 && term[0] == '!' && !strings.ContainsAny(term[1:], "!~="):
+		requirement = Requirement{Path: term[1:], Op: SelectNotExists}
+	case strings.HasPrefix(term[i:], "!="):
+		requirement = Requirement{Path: term[:i], Op: SelectNotEquals, Value: term[i+2:]}
+	case strings.HasPrefix(term[i:], "~="):
+		requirement = Requirement{Path: term[:i], Op: SelectMatches, Value: term[i+2:]}
+	case strings.HasPrefix(term[i:], "=="):
+		requirement = Requirement{Path: term[:i], Op: SelectEquals, Value: term[i+2:]}
+	case term[i] == '=':
+		requirement = Requirement{Path: term[:i], Op: SelectEquals, Value: term[i+1:]}
+	default:
+		return Requirement{}, fmt.Errorf("invalid selector term %q", term)
+	}
+
+	requirement.Path = strings.TrimSpace(requirement.Path)
+	requirement.Value = strings.TrimSpace(requirement.Value)
+	if requirement.Path == "" {
+		return Requirement{}, fmt.Errorf("invalid selector term %q: missing key", term)
+	}
+	if requirement.Op == SelectMatches {
+		re, err := regexp.Compile(requirement.Value)
+		if err != nil {
+			return Requirement{}, fmt.Errorf("invalid selector term %q: %w", term, err)
+		}
+		requirement.re = re
+	}
+	return requirement, nil
+}
+
+// Matches reports whether the merged configuration meets every requirement.
+func (s Selector) Matches(data map[string]interface{}) bool {
+	for _, requirement := range s {
+		if !requirement.Matches(data) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether the merged configuration meets the requirement.
+func (r Requirement) Matches(data map[string]interface{}) bool {
+	value, exists := lookupPath(data, r.Path)
+	switch r.Op {
+	case SelectExists:
+		return exists
+	case SelectNotExists:
+		return !exists
+	case SelectNotEquals:
+		return !exists || !anyValue(value, func(s string) bool { return s == r.Value })
+	case SelectEquals:
+		return exists && anyValue(value, func(s string) bool { return s == r.Value })
+	case SelectMatches:
+		return exists && anyValue(value, r.re.MatchString)
+	}
+	return false
+}
+
+// anyValue applies match to the string form of a scalar, or of every item of
+// a list. Maps never match.
+func anyValue(value interface{}, match func(string) bool) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return false
+	case []interface{}:
+		for _, item := range v {
+			if anyValue(item, match) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(scalarString(value))
+}
+
+// RenderedHost is the merged configuration of a known host, or the error
+// that kept it from rendering.
+type RenderedHost struct {
+	Hostname string
+	Data     map[string]interface{}
+	Err      error
+}
+
+// RenderedHosts renders every known host of the snapshot that matches a
+// domain pattern. Hosts that rendered are kept for the lifetime of the
+// snapshot, which never changes, hosts that failed are rendered again on the
+// next call. The result must not be modified.
+func (s *Snapshot) RenderedHosts() ([]RenderedHost, error) {
+	s.renderMutex.Lock()
+	defer s.renderMutex.Unlock()
+
+	hosts, err := KnownHosts(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if s.rendered == nil {
+		s.rendered = make(map[string]RenderedHost, len(hosts))
+	}
+
+	rendered := make([]RenderedHost, 0, len(hosts))
+	for _, hostname := range hosts {
+		host, ok := s.rendered[hostname]
+		if !ok {
+			match, data, err := RenderHost(s, hostname)
+			if match == nil && err == nil {
+				continue
+			}
+			host = RenderedHost{Hostname: hostname, Data: data, Err: err}
+			if err == nil {
+				s.rendered[hostname] = host
+			}
+		}
+		rendered = append(rendered, host)
+	}
+	return rendered, nil
+}
+
+// SearchHosts returns the known hosts whose merged configuration matches the
+// selector and, by hostname, the hosts that failed to render. Sensitive keys
+// the client identities may not read are masked before matching, so they
+// can not be guessed with selectors.
+func SearchHosts(s *Snapshot, selector Selector, identities []string) ([]string, map[string]string, error) {
+	rendered, err := s.RenderedHosts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hosts := []string{}
+	failures := make(map[string]string)
+	for _, host := range rendered {
+		if host.Err != nil {
+			failures[host.Hostname] = host.Err.Error()
+			continue
+		}
+		if selector.Matches(s.Sensitive.Mask(host.Data, identities)) {
+			hosts = append(hosts, host.Hostname)
+		}
+	}
+	return hosts, failures, nil
+}
@@ -0,0 +1,158 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector    string
+		expected    Selector
+		expectedErr bool
+	}{
+		{selector: "ldap=slcldap1", expected: Selector{{Path: "ldap", Op: SelectEquals, Value: "slcldap1"}}},
+		{selector: "ldap==slcldap1", expected: Selector{{Path: "ldap", Op: SelectEquals, Value: "slcldap1"}}},
+		{selector: "features.vpn != true", expected: Selector{{Path: "features.vpn", Op: SelectNotEquals, Value: "true"}}},
+		{selector: "contact.support, !contact.pager", expected: Selector{
+			{Path: "contact.support", Op: SelectExists},
+			{Path: "contact.pager", Op: SelectNotExists},
+		}},
+		{selector: "a=b=c", expected: Selector{{Path: "a", Op: SelectEquals, Value: "b=c"}}},
+		{selector: "", expectedErr: true},
+		{selector: "=value", expectedErr: true},
+		{selector: "a!b", expectedErr: true},
+		{selector: "!a=b", expectedErr: true},
+		{selector: "a~=(", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selector, err := ParseSelector(tt.selector)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, selector)
+		})
+	}
+
+	selector, err := ParseSelector("ldap~=^slc")
+	assert.NoError(t, err)
+	assert.Equal(t, SelectMatches, selector[0].Op)
+	assert.Equal(t, "^slc", selector[0].Value)
+}
+
+func TestSelectorMatches(t *testing.T) {
+	data := map[string]interface{}{
+		"ldap":     "slcldap1.mgt.prod.example.com",
+		"instance": 1,
+		"ports":    []interface{}{8102, 8103},
+		"features": map[string]interface{}{"vpn": true, "firewall": false},
+	}
+
+	tests := []struct {
+		selector string
+		expected bool
+	}{
+		{"ldap=slcldap1.mgt.prod.example.com", true},
+		{"ldap=lakldap1.mgt.prod.example.com", false},
+		{"features.vpn=true", true},
+		{"features.vpn!=true", false},
+		{"features.missing!=true", true},
+		{"instance=1", true},
+		{"ports=8103", true},
+		{"ports!=8104", true},
+		{"ldap~=^slc", true},
+		{"ldap~=^lak", false},
+		{"features", true},
+		{"features=true", false},
+		{"features.firewall", true},
+		{"!features.firewall", false},
+		{"!features.ipv6", true},
+		{"ldap~=^slc,features.vpn=true", true},
+		{"ldap~=^slc,features.vpn=false", false},
+	}
+
+	for _, tt := range tests {
+		selector, err := ParseSelector(tt.selector)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, selector.Matches(data), tt.selector)
+	}
+}
+
+func TestSearchHosts(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"all.yaml":              "ldap: ldap.{{ .Datacenter }}\nfeatures:\n  vpn: false\n",
+		"devices/web1-slc.yaml": "features:\n  vpn: true\n",
+		"devices/db1-slc.yaml":  "a: 1\n",
+		"devices/db1-lax.yaml":  "a: 1\n",
+		"hosts.yaml":            "hosts: [printer, broken-lax]\n",
+		// Rendering fails, listed as a failure instead of failing the search
+		"devices/broken-lax.yaml": "a: {{ .Datacenter\n",
+	})
+	snapshot := &Snapshot{
+		Path:      repo,
+		Patterns:  []RegexPattern{{Name: "host", Regex: "^(?P<Function>[a-z]+)\\d*-(?P<Datacenter>[a-z]+)$"}},
+		Hierarchy: DefaultHierarchy,
+	}
+
+	search := func(query string) ([]string, map[string]string) {
+		selector, err := ParseSelector(query)
+		assert.NoError(t, err)
+		hosts, failures, err := SearchHosts(snapshot, selector, nil)
+		assert.NoError(t, err)
+		return hosts, failures
+	}
+
+	hosts, failures := search("ldap=ldap.slc")
+	assert.Equal(t, []string{"db1-slc", "web1-slc"}, hosts)
+	assert.Contains(t, failures, "broken-lax")
+	assert.Len(t, failures, 1)
+
+	hosts, _ = search("features.vpn=true")
+	assert.Equal(t, []string{"web1-slc"}, hosts)
+
+	hosts, _ = search("ldap=ldap.nyc")
+	assert.Equal(t, []string{}, hosts)
+
+	t.Run("results are cached per snapshot", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(repo, "devices", "web1-slc.yaml"), []byte("a: 1\n"), 0o644); err != nil {
+			t.Fatalf("Failed to change device: %v", err)
+		}
+		hosts, _ := search("features.vpn=true")
+		assert.Equal(t, []string{"web1-slc"}, hosts)
+	})
+
+	t.Run("failed hosts are rendered again", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(repo, "devices", "broken-lax.yaml"), []byte("a: 1\n"), 0o644); err != nil {
+			t.Fatalf("Failed to fix device: %v", err)
+		}
+		hosts, failures := search("ldap=ldap.lax")
+		assert.Equal(t, []string{"broken-lax", "db1-lax"}, hosts)
+		assert.Empty(t, failures)
+	})
+}
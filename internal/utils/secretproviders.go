@@ -0,0 +1,259 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider looks up secrets kept outside of the config repository for
+// the secret template function. Paths are slash separated, their meaning is
+// up to the provider.
+type SecretProvider interface {
+	Secret(path string) (string, error)
+}
+
+// DirSecretProvider reads secrets from the files below Root, e.g. secrets
+// mounted into a container. A single trailing newline is removed.
+type DirSecretProvider struct {
+	Root string
+}
+
+func (p *DirSecretProvider) Secret(path string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(path)) {
+		return "", fmt.Errorf("secret path %s is outside of the secret directory", path)
+	}
+	content, err := os.ReadFile(filepath.Join(p.Root, filepath.FromSlash(path)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("secret %s does not exist", path)
+		}
+		return "", err
+	}
+	value := strings.TrimSuffix(string(content), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+// ExecSecretProvider runs a plugin for every lookup. The plugin reads
+// {"path": "..."} from stdin and answers {"value": "..."} or
+// {"error": "..."} on stdout.
+type ExecSecretProvider struct {
+	Command []string
+	Timeout time.Duration
+}
+
+type execSecretRequest struct {
+	Path string `json:"path"`
+}
+
+type execSecretResponse struct {
+	Value *string `json:"value"`
+	Error string  `json:"error"`
+}
+
+func (p *ExecSecretProvider) Secret(path string) (string, error) {
+	if len(p.Command) == 0 {
+		return "", errors.New("secret plugin command is empty")
+	}
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	request, err := json.Marshal(execSecretRequest{Path: path})
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(request)
+	// Children of a killed plugin can keep its output open
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("secret plugin %s failed for %s: %w: %s", p.Command[0], path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var response execSecretResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return "", fmt.Errorf("secret plugin %s: invalid response for %s: %w", p.Command[0], path, err)
+	}
+	if response.Error != "" {
+		return "", fmt.Errorf("secret plugin %s: %s: %s", p.Command[0], path, response.Error)
+	}
+	if response.Value == nil {
+		return "", fmt.Errorf("secret plugin %s: no value for %s", p.Command[0], path)
+	}
+	return *response.Value, nil
+}
+
+// SecretCache resolves secrets through a provider and keeps them for TTL.
+// It remembers every value it returned so responses can be redacted.
+type SecretCache struct {
+	provider SecretProvider
+	ttl      time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]cachedSecret
+	values  map[string]struct{}
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// GlobalSecrets backs the secret template function, nil when no secret
+// provider is configured.
+var GlobalSecrets *SecretCache
+
+var errNoSecretProvider = errors.New("no secret provider is configured")
+
+// RedactedValue replaces secrets in redacted responses.
+const RedactedValue = "[REDACTED]"
+
+func NewSecretCache(provider SecretProvider, ttl time.Duration) *SecretCache {
+	return &SecretCache{
+		provider: provider,
+		ttl:      ttl,
+		entries:  make(map[string]cachedSecret),
+		values:   make(map[string]struct{}),
+	}
+}
+
+// NewSecretProvider returns the provider configured in the settings, nil if
+// there is none.
+func NewSecretProvider(settings *Settings) (SecretProvider, error) {
+	switch {
+	case settings.SecretDir != "" && settings.SecretCommand != "":
+		return nil, errors.New("SecretDir and SecretCommand can not be used together")
+	case settings.SecretDir != "":
+		return &DirSecretProvider{Root: settings.SecretDir}, nil
+	case settings.SecretCommand != "":
+		return &ExecSecretProvider{Command: strings.Fields(settings.SecretCommand), Timeout: settings.SecretTimeout}, nil
+	}
+	return nil, nil
+}
+
+// Secret returns the cached value of path, asking the provider once it
+// expired. Failed lookups are not cached.
+func (c *SecretCache) Secret(path string) (string, error) {
+	if c == nil {
+		return "", errNoSecretProvider
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.entries[path]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := c.provider.Secret(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	c.entries[path] = cachedSecret{value: value, expires: time.Now().Add(c.ttl)}
+	if value != "" {
+		c.values[value] = struct{}{}
+	}
+	c.mutex.Unlock()
+	return value, nil
+}
+
+// minRedactedSubstring is the length a secret needs to be redacted where
+// it is only part of a string. Shorter secrets, like 1 or true, would
+// mangle unrelated values, they are only redacted where a value equals them.
+const minRedactedSubstring = 8
+
+// Redact returns a copy of value with every secret the cache ever returned
+// replaced by RedactedValue. Values equal to a secret are replaced whole,
+// secrets of at least minRedactedSubstring characters also where they are
+// only part of a string.
+func (c *SecretCache) Redact(value interface{}) interface{} {
+	if c == nil {
+		return value
+	}
+
+	c.mutex.Lock()
+	secrets := make(map[string]struct{}, len(c.values))
+	var substrings []string
+	for secret := range c.values {
+		secrets[secret] = struct{}{}
+		if len(secret) >= minRedactedSubstring {
+			substrings = append(substrings, secret)
+		}
+	}
+	c.mutex.Unlock()
+
+	// Longer secrets first, so one containing another is replaced whole
+	sort.Slice(substrings, func(i, j int) bool { return len(substrings[i]) > len(substrings[j]) })
+	return redact(value, secrets, substrings)
+}
+
+func redact(value interface{}, secrets map[string]struct{}, substrings []string) interface{} {
+	switch typed := value.(type) {
+	case string:
+		if _, ok := secrets[typed]; ok {
+			return RedactedValue
+		}
+		for _, secret := range substrings {
+			typed = strings.ReplaceAll(typed, secret, RedactedValue)
+		}
+		return typed
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(typed))
+		for key, nested := range typed {
+			redacted[key] = redact(nested, secrets, substrings)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(typed))
+		for i, item := range typed {
+			redacted[i] = redact(item, secrets, substrings)
+		}
+		return redacted
+	case nil:
+		return nil
+	}
+
+	// Numbers and booleans rendered from a secret keep their type unless
+	// they are the secret
+	if _, ok := secrets[fmt.Sprint(value)]; ok {
+		return RedactedValue
+	}
+	return value
+}
@@ -0,0 +1,193 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingProvider counts lookups and returns the path reversed.
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Secret(path string) (string, error) {
+	p.calls++
+	runes := []rune(path)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes), nil
+}
+
+func TestDirSecretProvider(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"db/password": "s3cr3t\n",
+		"db/token":    "t0ken\r\n",
+		"db/raw":      "two\nlines",
+	})
+	provider := &DirSecretProvider{Root: root}
+
+	for path, expected := range map[string]string{"db/password": "s3cr3t", "db/token": "t0ken", "db/raw": "two\nlines"} {
+		value, err := provider.Secret(path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, expected, value, path)
+	}
+
+	_, err := provider.Secret("db/missing")
+	assert.ErrorContains(t, err, "secret db/missing does not exist")
+	for _, path := range []string{"../outside", "/etc/passwd", ""} {
+		_, err := provider.Secret(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestExecSecretProvider(t *testing.T) {
+	plugin := filepath.Join(t.TempDir(), "plugin.sh")
+	script := `#!/bin/sh
+read -r request
+case "$request" in
+  *'"db/password"'*) echo '{"value": "s3cr3t"}' ;;
+  *'"broken"'*) echo 'not json' ;;
+  *'"crash"'*) echo 'plugin crashed' >&2; exit 3 ;;
+  *'"slow"'*) sleep 5 ;;
+  *) echo '{"error": "not found"}' ;;
+esac
+`
+	if err := os.WriteFile(plugin, []byte(script), 0o755); err != nil {
+		t.Fatalf("Failed to write plugin: %v", err)
+	}
+	provider := &ExecSecretProvider{Command: []string{plugin}, Timeout: time.Second}
+
+	value, err := provider.Secret("db/password")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+
+	_, err = provider.Secret("db/missing")
+	assert.ErrorContains(t, err, "db/missing: not found")
+	_, err = provider.Secret("broken")
+	assert.ErrorContains(t, err, "invalid response")
+	_, err = provider.Secret("crash")
+	assert.ErrorContains(t, err, "plugin crashed")
+
+	start := time.Now()
+	_, err = provider.Secret("slow")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestSecretCache(t *testing.T) {
+	provider := &countingProvider{}
+	cache := NewSecretCache(provider, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		value, err := cache.Secret("abc")
+		assert.NoError(t, err)
+		assert.Equal(t, "cba", value)
+	}
+	assert.Equal(t, 1, provider.calls)
+
+	t.Run("expired entries are looked up again", func(t *testing.T) {
+		expiring := NewSecretCache(provider, time.Nanosecond)
+		expiring.Secret("abc")
+		time.Sleep(time.Millisecond)
+		expiring.Secret("abc")
+		assert.Equal(t, 3, provider.calls)
+	})
+
+	t.Run("redact", func(t *testing.T) {
+		cache.Secret("4321")
+		cache.Secret("drowssap-gnol")
+		data := map[string]interface{}{
+			"password": "cba",
+			"dsn":      "postgres://app:long-password@db",
+			"short":    "postgres://app:cba@db",
+			"port":     1234,
+			"list":     []interface{}{"cba", "public", nil},
+		}
+		assert.Equal(t, map[string]interface{}{
+			"password": RedactedValue,
+			"dsn":      "postgres://app:" + RedactedValue + "@db",
+			"short":    "postgres://app:cba@db",
+			"port":     RedactedValue,
+			"list":     []interface{}{RedactedValue, "public", nil},
+		}, cache.Redact(data))
+		assert.Equal(t, "cba", data["password"], "the original is not modified")
+
+		var none *SecretCache
+		assert.Equal(t, data, none.Redact(data))
+	})
+
+	t.Run("one-character secret", func(t *testing.T) {
+		short := NewSecretCache(&countingProvider{}, time.Hour)
+		short.Secret("1")
+		data := map[string]interface{}{
+			"replicas": 1,
+			"port":     8102,
+			"ratio":    0.1,
+			"enabled":  true,
+			"name":     "web1",
+			"token":    "1",
+		}
+		assert.Equal(t, map[string]interface{}{
+			"replicas": RedactedValue,
+			"port":     8102,
+			"ratio":    0.1,
+			"enabled":  true,
+			"name":     "web1",
+			"token":    RedactedValue,
+		}, short.Redact(data))
+	})
+
+	t.Run("secret template function", func(t *testing.T) {
+		repo := t.TempDir()
+		writeFiles(t, repo, map[string]string{
+			"all.yaml": "password: {{ secret \"db/password\" }}\n",
+		})
+		snapshot := &Snapshot{Path: repo, Hierarchy: DefaultHierarchy}
+		defer func() { GlobalSecrets = nil }()
+
+		_, err := ResolveLayers(snapshot, "web1", nil)
+		assert.ErrorIs(t, err, errNoSecretProvider)
+
+		GlobalSecrets = cache
+		layers, err := ResolveLayers(snapshot, "web1", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"password": "drowssap/bd"}, MergeLayers(layers))
+	})
+
+	t.Run("quoted with toJson", func(t *testing.T) {
+		repo := t.TempDir()
+		writeFiles(t, repo, map[string]string{
+			"all.yaml": "password: {{ secret \"{]x!: a# *\" | toJson }}\n",
+		})
+		GlobalSecrets = cache
+		defer func() { GlobalSecrets = nil }()
+
+		layers, err := ResolveLayers(&Snapshot{Path: repo, Hierarchy: DefaultHierarchy}, "web1", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"password": "* #a :!x]{"}, MergeLayers(layers))
+	})
+}
@@ -0,0 +1,193 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Encrypted scalars look like ENC[age:<base64 age ciphertext>]. Whole layer
+// files can be encrypted as well, in the ASCII armored or binary age format.
+const (
+	encryptedValuePrefix = "ENC[age:"
+	encryptedValueSuffix = "]"
+	ageBinaryHeader      = "age-encryption.org/v1\n"
+)
+
+// SecretKeys holds the age identities encrypted values and files of the
+// config repository are decrypted with.
+type SecretKeys struct {
+	identities []age.Identity
+}
+
+// GlobalSecretKeys is used while rendering layers, nil when no
+// SecretKeyFile is configured.
+var GlobalSecretKeys *SecretKeys
+
+var errNoSecretKeys = errors.New("no SecretKeyFile is configured to decrypt it")
+
+// LoadSecretKeys reads an age identity file, as written by age-keygen.
+func LoadSecretKeys(path string) (*SecretKeys, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("secret key file %s: %w", path, err)
+	}
+	return &SecretKeys{identities: identities}, nil
+}
+
+// EncryptValue encrypts a scalar for the recipients, the result can be used
+// as a value in any layer file.
+func EncryptValue(plaintext []byte, recipients ...age.Recipient) (string, error) {
+	var ciphertext bytes.Buffer
+	if err := encrypt(&ciphertext, plaintext, recipients); err != nil {
+		return "", err
+	}
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(ciphertext.Bytes()) + encryptedValueSuffix, nil
+}
+
+// EncryptFile encrypts a whole layer file for the recipients, ASCII armored
+// so it can be reviewed as text.
+func EncryptFile(plaintext []byte, recipients ...age.Recipient) ([]byte, error) {
+	var output bytes.Buffer
+	armored := armor.NewWriter(&output)
+	if err := encrypt(armored, plaintext, recipients); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+func encrypt(dst io.Writer, plaintext []byte, recipients []age.Recipient) error {
+	w, err := age.Encrypt(dst, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (k *SecretKeys) decrypt(ciphertext io.Reader) ([]byte, error) {
+	if k == nil {
+		return nil, errNoSecretKeys
+	}
+	plaintext, err := age.Decrypt(ciphertext, k.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(plaintext)
+}
+
+// decryptFile returns the content of a layer file, decrypted if the whole
+// file is encrypted and unchanged otherwise.
+func (k *SecretKeys) decryptFile(content []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte(armor.Header)):
+		return k.decrypt(armor.NewReader(bytes.NewReader(trimmed)))
+	case bytes.HasPrefix(content, []byte(ageBinaryHeader)):
+		return k.decrypt(bytes.NewReader(content))
+	}
+	return content, nil
+}
+
+// decryptValues replaces the encrypted scalars in a decoded layer with their
+// plaintext. Errors name the key of the value, never its content.
+func (k *SecretKeys) decryptValues(prefix string, value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		if !strings.HasPrefix(typed, encryptedValuePrefix) || !strings.HasSuffix(typed, encryptedValueSuffix) {
+			return typed, nil
+		}
+		plaintext, err := k.decryptValue(strings.TrimSuffix(strings.TrimPrefix(typed, encryptedValuePrefix), encryptedValueSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("encrypted value %s: %w", prefix, err)
+		}
+		return string(plaintext), nil
+	case map[string]interface{}:
+		for _, key := range sortedKeys(typed) {
+			decrypted, err := k.decryptValues(joinPath(prefix, key), typed[key])
+			if err != nil {
+				return nil, err
+			}
+			typed[key] = decrypted
+		}
+	case []interface{}:
+		for i, item := range typed {
+			decrypted, err := k.decryptValues(fmt.Sprintf("%s[%d]", prefix, i), item)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = decrypted
+		}
+	case MergeDirective:
+		decrypted, err := k.decryptValues(prefix, typed.Value)
+		if err != nil {
+			return nil, err
+		}
+		typed.Value = decrypted
+		return typed, nil
+	}
+	return value, nil
+}
+
+// decryptValue decrypts the base64 age ciphertext of an encrypted scalar.
+func (k *SecretKeys) decryptValue(encoded string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return k.decrypt(bytes.NewReader(ciphertext))
+}
+
+// encryptedValue finds encrypted scalars in the text of a layer file.
+var encryptedValue = regexp.MustCompile(regexp.QuoteMeta(encryptedValuePrefix) + `([^\]]*)` + regexp.QuoteMeta(encryptedValueSuffix))
+
+// checkValues decrypts every encrypted scalar in the text of a layer file,
+// before it is rendered, and reports the lines of those that fail. The
+// plaintext is discarded.
+func (k *SecretKeys) checkValues(content []byte) []string {
+	var problems []string
+	for _, match := range encryptedValue.FindAllSubmatchIndex(content, -1) {
+		if _, err := k.decryptValue(string(content[match[2]:match[3]])); err != nil {
+			line := bytes.Count(content[:match[0]], []byte("\n")) + 1
+			problems = append(problems, fmt.Sprintf("line %d: encrypted value: %v", line, err))
+		}
+	}
+	return problems
+}
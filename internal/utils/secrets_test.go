@@ -0,0 +1,120 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(keyFile, []byte("# test key\n"+identity.String()+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	keys, err := LoadSecretKeys(keyFile)
+	assert.NoError(t, err)
+
+	password, err := EncryptValue([]byte("s3cr3t"), identity.Recipient())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(password, "ENC[age:"), password)
+	encryptedFile, err := EncryptFile([]byte("token: {{ .Function }}-t0ken\n"), identity.Recipient())
+	assert.NoError(t, err)
+
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"all.yaml":              "db:\n  user: app\n  password: " + password + "\nlist:\n  - " + password + "\n",
+		"functions/web.yaml":    string(encryptedFile),
+		"devices/web1-slc.yaml": "users: !append [\"" + password + "\"]\ndsn: \"{{ .Config.db.user }}:{{ .Config.db.password }}\"\n",
+	})
+	snapshot := &Snapshot{
+		Path:      repo,
+		Patterns:  []RegexPattern{{Name: "host", Regex: "^(?P<Function>[a-z]+)(?P<Instance>\\d+)-(?P<Datacenter>[a-z]+)$"}},
+		Hierarchy: DefaultHierarchy,
+	}
+	t.Cleanup(func() { GlobalSecretKeys = nil })
+
+	t.Run("values and files are decrypted while rendering", func(t *testing.T) {
+		GlobalSecretKeys = keys
+		_, data, err := RenderHost(snapshot, "web1-slc")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"db":    map[string]interface{}{"user": "app", "password": "s3cr3t"},
+			"list":  []interface{}{"s3cr3t"},
+			"token": "web-t0ken",
+			"users": []interface{}{"s3cr3t"},
+			"dsn":   "app:s3cr3t",
+		}, data)
+		assert.NoError(t, ValidateSnapshot(snapshot, []string{"web1-slc"}))
+	})
+
+	t.Run("without keys", func(t *testing.T) {
+		GlobalSecretKeys = nil
+		_, _, err := RenderHost(snapshot, "web1-slc")
+		assert.ErrorContains(t, err, "encrypted value db.password: no SecretKeyFile is configured")
+
+		err = ValidateSnapshot(snapshot, nil)
+		assert.ErrorContains(t, err, "functions/web.yaml: encrypted file")
+		assert.ErrorContains(t, err, "all.yaml: line 3: encrypted value")
+	})
+
+	t.Run("with the wrong key", func(t *testing.T) {
+		other, _ := age.GenerateX25519Identity()
+		GlobalSecretKeys = &SecretKeys{identities: []age.Identity{other}}
+		_, _, err := RenderHost(snapshot, "web1-slc")
+		assert.ErrorContains(t, err, "encrypted value db.password")
+		assert.NotContains(t, err.Error(), "s3cr3t")
+
+		// Validation catches the values without sample hosts
+		GlobalSecretKeys = keys
+		assert.NoError(t, ValidateSnapshot(snapshot, nil))
+		GlobalSecretKeys = &SecretKeys{identities: []age.Identity{other}}
+		err = ValidateSnapshot(snapshot, nil)
+		var validationErr *ValidationError
+		if assert.True(t, errors.As(err, &validationErr)) {
+			assert.Len(t, validationErr.Problems, 4, "functions/web.yaml, all.yaml twice and devices/web1-slc.yaml")
+		}
+		assert.ErrorContains(t, err, "all.yaml: line 5: encrypted value")
+		assert.ErrorContains(t, err, "devices/web1-slc.yaml: line 1: encrypted value")
+	})
+
+	t.Run("corrupt value", func(t *testing.T) {
+		GlobalSecretKeys = keys
+		corrupt := &Snapshot{Path: t.TempDir(), Hierarchy: DefaultHierarchy}
+		writeFiles(t, corrupt.Path, map[string]string{"all.yaml": "a: 1\nb: ENC[age:bm90IGFnZQ==]\n"})
+		assert.ErrorContains(t, ValidateSnapshot(corrupt, nil), "all.yaml: line 2: encrypted value")
+	})
+
+	t.Run("invalid key file", func(t *testing.T) {
+		invalid := filepath.Join(t.TempDir(), "keys.txt")
+		assert.NoError(t, os.WriteFile(invalid, []byte("not a key\n"), 0o600))
+		_, err := LoadSecretKeys(invalid)
+		assert.Error(t, err)
+	})
+}
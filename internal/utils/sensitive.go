@@ -0,0 +1,219 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"strings"
+)
+
+// SensitiveRules is the content of sensitive.yaml. It marks keys of the
+// merged configuration as sensitive and names the clients allowed to read
+// them, everybody else gets RedactedValue instead.
+type SensitiveRules struct {
+	// Readers may read every sensitive key
+	Readers []string       `yaml:"readers"`
+	Keys    []SensitiveKey `yaml:"sensitive"`
+}
+
+// SensitiveKey is a dotted key path whose segments may use path.Match
+// globs, e.g. database.*.password. A pattern without dots is a naming
+// convention that matches the key at any depth, e.g. *_token. Everything
+// below a sensitive key is sensitive as well.
+type SensitiveKey struct {
+	Path string `yaml:"path"`
+	// Readers may read this key in addition to the global readers
+	Readers []string `yaml:"readers"`
+}
+
+// UnmarshalYAML accepts a plain string as a key without extra readers.
+func (k *SensitiveKey) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&k.Path)
+	}
+	type plain SensitiveKey
+	return node.Decode((*plain)(k))
+}
+
+// ReadSensitiveRules reads the rules from a YAML file. Without the file no
+// key is sensitive.
+func ReadSensitiveRules(filePath string) (SensitiveRules, error) {
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return SensitiveRules{}, nil
+	}
+	if err != nil {
+		return SensitiveRules{}, err
+	}
+
+	var rules SensitiveRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return SensitiveRules{}, err
+	}
+	for _, key := range rules.Keys {
+		if key.Path == "" {
+			return SensitiveRules{}, errors.New("sensitive.yaml: every sensitive key needs a path")
+		}
+		for _, pattern := range append(strings.Split(key.Path, "."), key.Readers...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return SensitiveRules{}, fmt.Errorf("sensitive.yaml: %s: %w", key.Path, err)
+			}
+		}
+	}
+	for _, reader := range rules.Readers {
+		if _, err := path.Match(reader, ""); err != nil {
+			return SensitiveRules{}, fmt.Errorf("sensitive.yaml: reader %s: %w", reader, err)
+		}
+	}
+	return rules, nil
+}
+
+// matches reports whether the key at keys, outermost first, is this
+// sensitive key. Parents are not considered.
+func (k SensitiveKey) matches(keys []string) bool {
+	patterns := strings.Split(k.Path, ".")
+	if len(patterns) == 1 {
+		ok, _ := path.Match(patterns[0], keys[len(keys)-1])
+		return ok
+	}
+	if len(patterns) != len(keys) {
+		return false
+	}
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, keys[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// hidden reports whether the value at keys is sensitive and none of the
+// client's identities may read it. A client without identities is
+// anonymous.
+func (rules SensitiveRules) hidden(keys []string, identities []string) bool {
+	for _, key := range rules.Keys {
+		if key.matches(keys) && !rules.mayRead(key, identities) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rules SensitiveRules) mayRead(key SensitiveKey, identities []string) bool {
+	for _, identity := range identities {
+		if identity != "" && (MatchesAny(rules.Readers, identity) || MatchesAny(key.Readers, identity)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesAny reports whether value matches one of the path.Match globs.
+// Invalid globs match nothing.
+func MatchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Sensitive reports whether the dotted key path, or one of its parents, is
+// sensitive. Log output uses it to mask values.
+func (rules SensitiveRules) Sensitive(dotted string) bool {
+	keys := strings.Split(dotted, ".")
+	for i := range keys {
+		for _, key := range rules.Keys {
+			if key.matches(keys[:i+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Mask returns data with every sensitive value none of the identities may
+// read replaced by RedactedValue. A client has several identities when its
+// certificate carries several names. Maps along the way are copied, data itself is
+// not modified. Items of a list share the key of the list.
+func (rules SensitiveRules) Mask(data map[string]interface{}, identities []string) map[string]interface{} {
+	if len(rules.Keys) == 0 {
+		return data
+	}
+	return rules.maskMap(nil, data, identities)
+}
+
+func (rules SensitiveRules) maskMap(parents []string, data map[string]interface{}, identities []string) map[string]interface{} {
+	masked := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		keys := append(append([]string{}, parents...), key)
+		if rules.hidden(keys, identities) {
+			masked[key] = RedactedValue
+			continue
+		}
+		masked[key] = rules.maskValue(keys, value, identities)
+	}
+	return masked
+}
+
+func (rules SensitiveRules) maskValue(keys []string, value interface{}, identities []string) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return rules.maskMap(keys, typed, identities)
+	case []interface{}:
+		masked := make([]interface{}, len(typed))
+		for i, item := range typed {
+			masked[i] = rules.maskValue(keys, item, identities)
+		}
+		return masked
+	}
+	return value
+}
+
+// MaskProvenance masks the values of an explanation like Mask, including
+// the values that were overridden. Keys are dotted leaf paths.
+func (rules SensitiveRules) MaskProvenance(explained map[string]*Provenance, identities []string) {
+	if len(rules.Keys) == 0 {
+		return
+	}
+	for dotted, provenance := range explained {
+		keys := strings.Split(dotted, ".")
+		provenance.Value = rules.maskLeaf(keys, provenance.Value, identities)
+		for i := range provenance.Overrides {
+			provenance.Overrides[i].Value = rules.maskLeaf(keys, provenance.Overrides[i].Value, identities)
+		}
+	}
+}
+
+// maskLeaf masks the value at a dotted leaf path, whose parents were not
+// masked along the way.
+func (rules SensitiveRules) maskLeaf(keys []string, value interface{}, identities []string) interface{} {
+	for i := range keys {
+		if rules.hidden(keys[:i+1], identities) {
+			return RedactedValue
+		}
+	}
+	return rules.maskValue(keys, value, identities)
+}
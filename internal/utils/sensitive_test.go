@@ -0,0 +1,139 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSensitiveRules(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		rules, err := ReadSensitiveRules(filepath.Join(t.TempDir(), "sensitive.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, SensitiveRules{}, rules)
+	})
+
+	t.Run("keys as strings and maps", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sensitive.yaml")
+		content := "readers: [ops]\nsensitive:\n  - \"*_token\"\n  - path: database.password\n    readers: [dba, \"*.db.example.com\"]\n"
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+		rules, err := ReadSensitiveRules(path)
+		assert.NoError(t, err)
+		assert.Equal(t, SensitiveRules{
+			Readers: []string{"ops"},
+			Keys: []SensitiveKey{
+				{Path: "*_token"},
+				{Path: "database.password", Readers: []string{"dba", "*.db.example.com"}},
+			},
+		}, rules)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		for _, content := range []string{
+			"sensitive:\n  - path: \"\"\n",
+			"sensitive:\n  - \"database.[\"\n",
+			"readers: [\"[\"]\n",
+			"sensitive: {}\n",
+		} {
+			path := filepath.Join(t.TempDir(), "sensitive.yaml")
+			assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			_, err := ReadSensitiveRules(path)
+			assert.Error(t, err, content)
+		}
+	})
+}
+
+func TestSensitiveRulesMask(t *testing.T) {
+	rules := SensitiveRules{
+		Readers: []string{"ops"},
+		Keys: []SensitiveKey{
+			{Path: "*_token"},
+			{Path: "database.*.password", Readers: []string{"*.db.example.com"}},
+			{Path: "vault"},
+		},
+	}
+	data := map[string]interface{}{
+		"api_token": "t0ken",
+		"database": map[string]interface{}{
+			"main": map[string]interface{}{"user": "app", "password": "s3cr3t"},
+		},
+		"vault":    map[string]interface{}{"role": "app"},
+		"services": []interface{}{map[string]interface{}{"name": "web", "deploy_token": "d3ploy"}},
+		"owner":    "team",
+	}
+
+	t.Run("anonymous clients see placeholders", func(t *testing.T) {
+		assert.Equal(t, map[string]interface{}{
+			"api_token": RedactedValue,
+			"database": map[string]interface{}{
+				"main": map[string]interface{}{"user": "app", "password": RedactedValue},
+			},
+			"vault":    RedactedValue,
+			"services": []interface{}{map[string]interface{}{"name": "web", "deploy_token": RedactedValue}},
+			"owner":    "team",
+		}, rules.Mask(data, nil))
+		assert.Equal(t, "s3cr3t", data["database"].(map[string]interface{})["main"].(map[string]interface{})["password"], "the original is not modified")
+	})
+
+	t.Run("readers of a key", func(t *testing.T) {
+		masked := rules.Mask(data, []string{"pg1.db.example.com"})
+		assert.Equal(t, "s3cr3t", masked["database"].(map[string]interface{})["main"].(map[string]interface{})["password"])
+		assert.Equal(t, RedactedValue, masked["api_token"])
+	})
+
+	t.Run("any identity of the client", func(t *testing.T) {
+		// A certificate whose common name is no reader but a DNS name is
+		masked := rules.Mask(data, []string{"pg1", "pg1.db.example.com"})
+		assert.Equal(t, "s3cr3t", masked["database"].(map[string]interface{})["main"].(map[string]interface{})["password"])
+		assert.Equal(t, RedactedValue, rules.Mask(data, []string{"pg1", ""})["database"].(map[string]interface{})["main"].(map[string]interface{})["password"])
+	})
+
+	t.Run("global readers", func(t *testing.T) {
+		assert.Equal(t, data, rules.Mask(data, []string{"ops"}))
+	})
+
+	t.Run("without rules", func(t *testing.T) {
+		assert.Equal(t, data, SensitiveRules{}.Mask(data, nil))
+	})
+
+	t.Run("sensitive paths", func(t *testing.T) {
+		assert.True(t, rules.Sensitive("ci_token"))
+		assert.True(t, rules.Sensitive("vault.role"))
+		assert.True(t, rules.Sensitive("database.main.password"))
+		assert.False(t, rules.Sensitive("database.main.user"))
+	})
+
+	t.Run("provenance", func(t *testing.T) {
+		explained := ExplainLayers([]Layer{
+			{Name: "common", Path: "all.yaml", Data: map[string]interface{}{"api_token": "old", "owner": "team"}},
+			{Name: "device", Path: "devices/web1.yaml", Data: map[string]interface{}{"api_token": "new", "vault": map[string]interface{}{"role": "app"}}},
+		})
+		rules.MaskProvenance(explained, nil)
+
+		assert.Equal(t, RedactedValue, explained["api_token"].Value)
+		assert.Equal(t, RedactedValue, explained["api_token"].Overrides[0].Value)
+		assert.Equal(t, RedactedValue, explained["vault.role"].Value)
+		assert.Equal(t, "team", explained["owner"].Value)
+	})
+}
@@ -20,22 +20,48 @@
 package utils
 
 import (
+	"errors"
 	"github.com/spf13/viper"
+	"strings"
+	"time"
 )
 
 type Settings struct {
-	HTTPPort      string
-	HTTPSPort     string
-	ListenAddress string
-	HTTPEnabled   string
-	HTTPRedirect  string
-	CertPath      string
-	KeyPath       string
-	HTTPAddr      string // Combined address for HTTP
-	HTTPSAddr     string // Combined address for HTTPS
-	DebugLog      string
-	RepoAddress   string
-	RepoBranch    string
+	HTTPPort            string
+	HTTPSPort           string
+	ListenAddress       string
+	HTTPEnabled         string
+	HTTPRedirect        string
+	CertPath            string
+	KeyPath             string
+	HTTPAddr            string // Combined address for HTTP
+	HTTPSAddr           string // Combined address for HTTPS
+	DebugLog            string
+	RepoAddress         string
+	RepoBranch          string
+	RepoBranches        []string // Branch names or globs served as environments
+	ValidationHosts     []string // Hostnames rendered to validate a new commit
+	WebhookSecret       string
+	PollInterval        time.Duration
+	PollJitter          time.Duration // Random extra wait added to every poll
+	FetchTimeout        time.Duration
+	FailureBackoff      time.Duration // First retry delay after a failed fetch
+	MaxFailureBackoff   time.Duration
+	RepoCredentials     GitCredentials
+	RepoCredentialsFile string // Overrides RepoCredentials, reloaded on change
+	SecretKeyFile       string // age identities for encrypted values and files
+	SecretDir           string // Directory tree read by the secret template function
+	SecretCommand       string // Plugin run by the secret template function
+	SecretTimeout       time.Duration
+	SecretCacheTTL      time.Duration
+	ClientTokensFile    string   // Bearer tokens identifying API clients
+	ClientCAPath        string   // CA bundle verifying client certificates, enables mTLS
+	ClientAuth          string   // required or optional client certificates
+	DetailsPolicy       string   // open or self-only
+	AdminClients        []string // Client names allowed to fetch every host
+	CADir               string   // Key and state of the internal CA, enables it
+	CACertTTL           time.Duration
+	CAAutosign          []string // Hostname globs enrolled without a bootstrap token
 }
 
 func LoadSettings() (*Settings, error) {
@@ -48,6 +74,16 @@ func LoadSettings() (*Settings, error) {
 	viper.SetDefault("KeyPath", "./key.pem")
 	viper.SetDefault("DebugLog", "false")
 	viper.SetDefault("RepoBranch", "main")
+	viper.SetDefault("PollInterval", "20m")
+	viper.SetDefault("PollJitter", "0s")
+	viper.SetDefault("FetchTimeout", "2m")
+	viper.SetDefault("FailureBackoff", "30s")
+	viper.SetDefault("MaxFailureBackoff", "20m")
+	viper.SetDefault("RepoSSHUser", "git")
+	viper.SetDefault("SecretTimeout", "10s")
+	viper.SetDefault("SecretCacheTTL", "5m")
+	viper.SetDefault("DetailsPolicy", DetailsPolicyOpen)
+	viper.SetDefault("CACertTTL", "24h")
 
 	viper.SetEnvPrefix("CN")
 	viper.AutomaticEnv()
@@ -66,21 +102,89 @@ func LoadSettings() (*Settings, error) {
 			return nil, err
 		}
 	}
+	if viper.GetDuration("PollInterval") <= 0 {
+		return nil, errors.New("PollInterval must be a positive duration")
+	}
+	if policy := viper.GetString("DetailsPolicy"); policy != DetailsPolicyOpen && policy != DetailsPolicySelfOnly {
+		return nil, errors.New("DetailsPolicy must be open or self-only")
+	}
+	if viper.GetDuration("CACertTTL") <= 0 {
+		return nil, errors.New("CACertTTL must be a positive duration")
+	}
+	// Certificates of the internal CA alone can not be required, new hosts
+	// would have no way to reach /pki/sign for their first certificate
+	clientAuth := viper.GetString("ClientAuth")
+	internalCAOnly := viper.GetString("CADir") != "" && viper.GetString("ClientCAPath") == ""
+	if clientAuth == "" {
+		clientAuth = ClientAuthRequired
+		if internalCAOnly {
+			clientAuth = ClientAuthOptional
+		}
+	}
+	if clientAuth == ClientAuthRequired && internalCAOnly {
+		return nil, errors.New("ClientAuth required needs a ClientCAPath when CADir is set, new hosts could not enroll")
+	}
+
 	httpaddr := viper.GetString("ListenAddress") + ":" + viper.GetString("HTTPPort")
 	httpsaddr := viper.GetString("ListenAddress") + ":" + viper.GetString("HTTPSPort")
 
 	return &Settings{
-		HTTPPort:      viper.GetString("HTTPPort"),
-		HTTPSPort:     viper.GetString("HTTPSPort"),
-		ListenAddress: viper.GetString("ListenAddress"),
-		HTTPEnabled:   viper.GetString("HTTPEnabled"),
-		HTTPRedirect:  viper.GetString("HTTPRedirect"),
-		CertPath:      viper.GetString("CertPath"),
-		KeyPath:       viper.GetString("KeyPath"),
-		HTTPAddr:      httpaddr,
-		HTTPSAddr:     httpsaddr,
-		DebugLog:      viper.GetString("DebugLog"),
-		RepoAddress:   viper.GetString("RepoAddress"),
-		RepoBranch:    viper.GetString("RepoBranch"),
+		HTTPPort:        viper.GetString("HTTPPort"),
+		HTTPSPort:       viper.GetString("HTTPSPort"),
+		ListenAddress:   viper.GetString("ListenAddress"),
+		HTTPEnabled:     viper.GetString("HTTPEnabled"),
+		HTTPRedirect:    viper.GetString("HTTPRedirect"),
+		CertPath:        viper.GetString("CertPath"),
+		KeyPath:         viper.GetString("KeyPath"),
+		HTTPAddr:        httpaddr,
+		HTTPSAddr:       httpsaddr,
+		DebugLog:        viper.GetString("DebugLog"),
+		RepoAddress:     viper.GetString("RepoAddress"),
+		RepoBranch:      viper.GetString("RepoBranch"),
+		RepoBranches:    splitList(viper.GetString("RepoBranches")),
+		ValidationHosts: splitList(viper.GetString("ValidationHosts")),
+		WebhookSecret:   viper.GetString("WebhookSecret"),
+
+		PollInterval:      viper.GetDuration("PollInterval"),
+		PollJitter:        viper.GetDuration("PollJitter"),
+		FetchTimeout:      viper.GetDuration("FetchTimeout"),
+		FailureBackoff:    viper.GetDuration("FailureBackoff"),
+		MaxFailureBackoff: viper.GetDuration("MaxFailureBackoff"),
+
+		RepoCredentials: GitCredentials{
+			Username:            viper.GetString("RepoUsername"),
+			Password:            viper.GetString("RepoPassword"),
+			Token:               viper.GetString("RepoToken"),
+			SSHUser:             viper.GetString("RepoSSHUser"),
+			SSHKeyPath:          viper.GetString("RepoSSHKeyPath"),
+			SSHKeyPassphrase:    viper.GetString("RepoSSHKeyPassphrase"),
+			KnownHostsPath:      viper.GetString("RepoKnownHostsPath"),
+			HostKeyFingerprints: splitList(viper.GetString("RepoHostKeyFingerprints")),
+		},
+		RepoCredentialsFile: viper.GetString("RepoCredentialsFile"),
+		SecretKeyFile:       viper.GetString("SecretKeyFile"),
+		SecretDir:           viper.GetString("SecretDir"),
+		SecretCommand:       viper.GetString("SecretCommand"),
+		SecretTimeout:       viper.GetDuration("SecretTimeout"),
+		SecretCacheTTL:      viper.GetDuration("SecretCacheTTL"),
+		ClientTokensFile:    viper.GetString("ClientTokensFile"),
+		ClientCAPath:        viper.GetString("ClientCAPath"),
+		ClientAuth:          clientAuth,
+		DetailsPolicy:       viper.GetString("DetailsPolicy"),
+		AdminClients:        splitList(viper.GetString("AdminClients")),
+		CADir:               viper.GetString("CADir"),
+		CACertTTL:           viper.GetDuration("CACertTTL"),
+		CAAutosign:          splitList(viper.GetString("CAAutosign")),
 	}, nil
 }
+
+// splitList splits a comma separated setting, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,251 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a read-only copy of the config repository at a single commit,
+// together with the domain patterns, hierarchy, Puppet mapping and
+// sensitive keys loaded from it.
+type Snapshot struct {
+	Commit    string
+	Path      string
+	Patterns  []RegexPattern
+	Hierarchy []HierarchyLevel
+	Strict    bool // Layer templates run with missingkey=error
+	Puppet    PuppetMapping
+	Sensitive SensitiveRules
+
+	owned      bool // Path was materialized by us and is removed once unused
+	refs       atomic.Int64
+	retired    atomic.Bool
+	removeOnce sync.Once
+
+	// Known hosts matched against the patterns once, see ListHosts
+	hostsOnce sync.Once
+	hosts     []HostEntry
+	hostsErr  error
+	// Known hosts that rendered, by hostname, see RenderedHosts
+	renderMutex sync.Mutex
+	rendered    map[string]RenderedHost
+	// Merged configurations looked up with hostConfig, by hostname
+	hostConfigs sync.Map
+}
+
+// SnapshotStore holds the active snapshot. Requests Acquire the snapshot
+// they work on and keep using it even if a newer one is activated meanwhile.
+type SnapshotStore struct {
+	current atomic.Pointer[Snapshot]
+}
+
+// GlobalSnapshots is the store the HTTP handlers serve from.
+var GlobalSnapshots SnapshotStore
+
+// LoadSnapshot reads the domain patterns, hierarchy, Puppet mapping and
+// sensitive keys of the repository checked out at path.
+func LoadSnapshot(commit, path string) (*Snapshot, error) {
+	patterns, err := ReadDomainMatchingPatterns(filepath.Join(path, "domains_regex.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	hierarchy, err := ReadHierarchy(filepath.Join(path, "hierarchy.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	puppet, err := ReadPuppetMapping(filepath.Join(path, "puppet.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sensitive, err := ReadSensitiveRules(filepath.Join(path, "sensitive.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Commit:    commit,
+		Path:      path,
+		Patterns:  patterns,
+		Hierarchy: hierarchy.Levels,
+		Strict:    hierarchy.Strict,
+		Puppet:    puppet,
+		Sensitive: sensitive,
+	}, nil
+}
+
+// Acquire returns the active snapshot, or nil if none was activated yet.
+// Every snapshot returned must be released with Release.
+func (st *SnapshotStore) Acquire() *Snapshot {
+	for {
+		s := st.current.Load()
+		if s == nil {
+			return nil
+		}
+		s.refs.Add(1)
+		// The snapshot may have been swapped out before we took our
+		// reference, in which case it could already be removed.
+		if st.current.Load() == s {
+			return s
+		}
+		s.Release()
+	}
+}
+
+// Current returns the active snapshot without acquiring it. Its files may
+// be removed at any time, so it must only be used for its metadata.
+func (st *SnapshotStore) Current() *Snapshot {
+	return st.current.Load()
+}
+
+// Activate makes s the active snapshot. The previous snapshot is removed
+// once the last request using it releases it.
+func (st *SnapshotStore) Activate(s *Snapshot) {
+	previous := st.current.Swap(s)
+	if previous != nil && previous != s {
+		previous.retire()
+	}
+
+	// Keep the global patterns in sync for callers that only need them for
+	// logging, they always describe the default environment
+	if st != &GlobalSnapshots {
+		return
+	}
+	GlobalDomainPatternsMutex.Lock()
+	GlobalDomainPatterns = s.Patterns
+	GlobalDomainPatternsMutex.Unlock()
+}
+
+// Release gives back a snapshot obtained from Acquire.
+func (s *Snapshot) Release() {
+	if s.refs.Add(-1) == 0 && s.retired.Load() {
+		s.remove()
+	}
+}
+
+func (s *Snapshot) retire() {
+	s.retired.Store(true)
+	if s.refs.Load() == 0 {
+		s.remove()
+	}
+}
+
+func (s *Snapshot) remove() {
+	if !s.owned {
+		return
+	}
+	s.removeOnce.Do(func() {
+		log.Debug().Str("Commit", s.Commit).Msg("Removing retired snapshot")
+		if err := removeReadOnlyTree(s.Path); err != nil {
+			log.Error().Err(err).Str("Path", s.Path).Msg("Failed to remove snapshot")
+		}
+	})
+}
+
+// materializeCommit writes the tree of a commit into a new directory below
+// parentDir and makes it read-only.
+func materializeCommit(commit *object.Commit, parentDir string) (string, error) {
+	dir, err := os.MkdirTemp(parentDir, commit.Hash.String()[:12]+"-")
+	if err != nil {
+		return "", err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		// Only regular files are served, symlinks could point outside of the snapshot
+		if f.Mode != filemode.Regular && f.Mode != filemode.Executable {
+			return nil
+		}
+		return writeSnapshotFile(filepath.Join(dir, filepath.FromSlash(f.Name)), f)
+	})
+	if err == nil {
+		err = makeReadOnly(dir)
+	}
+	if err != nil {
+		removeReadOnlyTree(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func writeSnapshotFile(path string, f *object.File) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	reader, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o444)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// makeReadOnly removes the write permission from every directory below root.
+func makeReadOnly(root string) error {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// Children first, a read-only parent would stop us from changing them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], 0o555); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeReadOnlyTree restores write permissions below root and removes it.
+func removeReadOnlyTree(root string) error {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(path, 0o755)
+		}
+		return nil
+	})
+	return os.RemoveAll(root)
+}
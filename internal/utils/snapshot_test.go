@@ -0,0 +1,87 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	newOwnedSnapshot := func(commit string) *Snapshot {
+		dir := filepath.Join(t.TempDir(), commit)
+		writeFiles(t, dir, map[string]string{"all.yaml": "commit: " + commit})
+		assert.NoError(t, makeReadOnly(dir))
+		return &Snapshot{Commit: commit, Path: dir, owned: true}
+	}
+
+	t.Run("nothing activated", func(t *testing.T) {
+		var store SnapshotStore
+		assert.Nil(t, store.Acquire())
+	})
+
+	t.Run("retired snapshot is removed once released", func(t *testing.T) {
+		var store SnapshotStore
+		first := newOwnedSnapshot("first")
+		second := newOwnedSnapshot("second")
+
+		store.Activate(first)
+		inFlight := store.Acquire()
+		assert.Same(t, first, inFlight)
+
+		store.Activate(second)
+		assert.Same(t, second, store.Current())
+
+		// The in-flight request keeps reading the snapshot it started with
+		_, err := os.Stat(filepath.Join(first.Path, "all.yaml"))
+		assert.NoError(t, err)
+
+		inFlight.Release()
+		_, err = os.Stat(first.Path)
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = os.Stat(second.Path)
+		assert.NoError(t, err)
+	})
+
+	t.Run("unused snapshot is removed when retired", func(t *testing.T) {
+		var store SnapshotStore
+		first := newOwnedSnapshot("first")
+
+		store.Activate(first)
+		store.Activate(newOwnedSnapshot("second"))
+
+		_, err := os.Stat(first.Path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("snapshots not owned by the store are kept", func(t *testing.T) {
+		var store SnapshotStore
+		dir := t.TempDir()
+
+		store.Activate(&Snapshot{Commit: "external", Path: dir})
+		store.Activate(&Snapshot{Commit: "next"})
+
+		_, err := os.Stat(dir)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,573 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// libraryFuncs are the general purpose functions of layer templates. Like in
+// the text/template builtins, the value a function works on comes last so
+// it can be piped: {{ .Function | replace "-" "_" | upper }}. The exceptions
+// keep the argument order of the functions they copy: append, merge, pick
+// and omit take the collection first as in Sprig, the network functions
+// take the prefix or address first as in Terraform.
+var libraryFuncs = template.FuncMap{
+	// Strings
+	"default":    defaultValue,
+	"empty":      isEmpty,
+	"coalesce":   coalesce,
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      title,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+	"split":      split,
+	"join":       join,
+	"quote":      func(v interface{}) string { return strconv.Quote(toString(v)) },
+	"squote":     func(v interface{}) string { return "'" + strings.ReplaceAll(toString(v), "'", "''") + "'" },
+	"indent":     indent,
+	"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+	"regexMatch": regexMatch,
+	"regexFind":  regexFind,
+	"regexReplaceAll": func(expr, replacement, s string) (string, error) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, replacement), nil
+	},
+
+	// Conversion
+	"atoi":     toInt,
+	"toInt":    toInt,
+	"toFloat":  toFloat,
+	"toString": toString,
+	"toBool":   toBool,
+
+	// Math, on integers
+	"add": func(a, b interface{}) (int, error) {
+		return intOp(a, b, func(x, y int) (int, error) { return x + y, nil })
+	},
+	"sub": func(a, b interface{}) (int, error) {
+		return intOp(a, b, func(x, y int) (int, error) { return x - y, nil })
+	},
+	"mul": func(a, b interface{}) (int, error) {
+		return intOp(a, b, func(x, y int) (int, error) { return x * y, nil })
+	},
+	"div": func(a, b interface{}) (int, error) { return intOp(a, b, divide) },
+	"mod": func(a, b interface{}) (int, error) { return intOp(a, b, modulo) },
+	"max": func(a, b interface{}) (int, error) {
+		return intOp(a, b, func(x, y int) (int, error) { return max(x, y), nil })
+	},
+	"min": func(a, b interface{}) (int, error) {
+		return intOp(a, b, func(x, y int) (int, error) { return min(x, y), nil })
+	},
+	"addf": func(a, b interface{}) (float64, error) {
+		return floatOp(a, b, func(x, y float64) float64 { return x + y })
+	},
+	"subf": func(a, b interface{}) (float64, error) {
+		return floatOp(a, b, func(x, y float64) float64 { return x - y })
+	},
+	"mulf": func(a, b interface{}) (float64, error) {
+		return floatOp(a, b, func(x, y float64) float64 { return x * y })
+	},
+	"divf": func(a, b interface{}) (float64, error) {
+		return floatOp(a, b, func(x, y float64) float64 { return x / y })
+	},
+	"round": func(v interface{}) (float64, error) { f, err := toFloat(v); return math.Round(f), err },
+	"seq":   seq,
+
+	// Network
+	"cidrhost":      cidrhost,
+	"cidrsubnet":    cidrsubnet,
+	"cidrnetmask":   cidrnetmask,
+	"cidrprefixlen": cidrprefixlen,
+	"cidrcontains":  cidrcontains,
+	"ipadd":         ipadd,
+
+	// Lists
+	"list":      func(items ...interface{}) []interface{} { return items },
+	"first":     first,
+	"last":      last,
+	"rest":      rest,
+	"append":    func(list interface{}, item interface{}) ([]interface{}, error) { return appendItems(list, item) },
+	"concat":    concat,
+	"uniq":      uniq,
+	"has":       has,
+	"sortAlpha": sortAlpha,
+
+	// Dicts
+	"dict":   dict,
+	"get":    func(key string, m map[string]interface{}) interface{} { return m[key] },
+	"hasKey": func(key string, m map[string]interface{}) bool { _, ok := m[key]; return ok },
+	"keys":   func(m map[string]interface{}) []string { return sortedKeys(m) },
+	"merge": func(dst, src map[string]interface{}) map[string]interface{} {
+		return DeepMerge(DeepMerge(map[string]interface{}{}, dst), src)
+	},
+	"pick": pick,
+	"omit": omit,
+
+	// Encoding
+	"toYaml":       toYAML,
+	"fromYaml":     fromYAML,
+	"toJson":       toJSON,
+	"toPrettyJson": toPrettyJSON,
+	"fromJson":     fromJSON,
+	"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":       b64dec,
+
+	// Hashing
+	"md5sum":    func(s string) string { sum := md5.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"sha1sum":   func(s string) string { sum := sha1.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"sha256sum": func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+}
+
+// defaultValue returns value, or def if value is empty: {{ .Role | default "none" }}
+func defaultValue(def interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || isEmpty(value[0]) {
+		return def
+	}
+	return value[0]
+}
+
+// isEmpty reports whether a value is missing, zero or an empty collection.
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+func coalesce(values ...interface{}) interface{} {
+	for _, value := range values {
+		if !isEmpty(value) {
+			return value
+		}
+	}
+	return nil
+}
+
+func title(s string) string {
+	previous := ' '
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(previous) || previous == '-' || previous == '_' {
+			previous = r
+			return unicode.ToTitle(r)
+		}
+		previous = r
+		return r
+	}, s)
+}
+
+func split(sep, s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, sep)
+}
+
+func join(sep string, list interface{}) (string, error) {
+	items, err := toList(list)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = toString(item)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// indent prefixes every line of s with spaces, for embedding toYaml output.
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func regexMatch(expr, s string) (bool, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+func regexFind(expr, s string) (string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+	return re.FindString(s), nil
+}
+
+// toInt converts numbers, numeric strings such as capture groups and
+// booleans to an int.
+func toInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int()), nil
+	case float32:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to an integer", v)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to an integer", value)
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to a number", v)
+		}
+		return f, nil
+	}
+	n, err := toInt(value)
+	return float64(n), err
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return scalarString(value)
+}
+
+func toBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(v))
+	}
+	n, err := toInt(value)
+	return n != 0, err
+}
+
+func intOp(a, b interface{}, op func(x, y int) (int, error)) (int, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y)
+}
+
+func floatOp(a, b interface{}, op func(x, y float64) float64) (float64, error) {
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+func divide(x, y int) (int, error) {
+	if y == 0 {
+		return 0, errDivisionByZero
+	}
+	return x / y, nil
+}
+
+func modulo(x, y int) (int, error) {
+	if y == 0 {
+		return 0, errDivisionByZero
+	}
+	return x % y, nil
+}
+
+// seq returns the integers from start to end, both included:
+// {{ range seq 1 3 }} iterates over 1, 2 and 3.
+func seq(start, end interface{}) ([]int, error) {
+	from, err := toInt(start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := toInt(end)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		return []int{}, nil
+	}
+	// The difference of two ints always fits in a uint
+	if uint(to)-uint(from) > 10000 {
+		return nil, errors.New("seq: at most 10000 values")
+	}
+	values := make([]int, 0, to-from+1)
+	for i := 0; i <= to-from; i++ {
+		values = append(values, from+i)
+	}
+	return values, nil
+}
+
+// toList turns any slice or array, such as a []string from split, into a
+// []interface{}.
+func toList(list interface{}) ([]interface{}, error) {
+	if list == nil {
+		return []interface{}{}, nil
+	}
+	if items, ok := list.([]interface{}); ok {
+		return items, nil
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a list, got %T", list)
+	}
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items, nil
+}
+
+func first(list interface{}) (interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+func last(list interface{}) (interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[len(items)-1], nil
+}
+
+func rest(list interface{}) ([]interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return []interface{}{}, err
+	}
+	return items[1:], nil
+}
+
+// appendItems returns a new list, the list passed in is never modified.
+func appendItems(list interface{}, items ...interface{}) ([]interface{}, error) {
+	existing, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]interface{}{}, existing...), items...), nil
+}
+
+func concat(lists ...interface{}) ([]interface{}, error) {
+	result := []interface{}{}
+	for _, list := range lists {
+		items, err := toList(list)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, items...)
+	}
+	return result, nil
+}
+
+func uniq(list interface{}) ([]interface{}, error) {
+	items, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	result := []interface{}{}
+	for _, item := range items {
+		found := false
+		for _, existing := range result {
+			if reflect.DeepEqual(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// has reports whether list contains item: {{ if has "web" .Roles }}
+func has(item interface{}, list interface{}) (bool, error) {
+	items, err := toList(list)
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range items {
+		if reflect.DeepEqual(existing, item) || toString(existing) == toString(item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func sortAlpha(list interface{}) ([]string, error) {
+	items, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]string, len(items))
+	for i, item := range items {
+		sorted[i] = toString(item)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// dict builds a map from key and value pairs: dict "name" .Function "port" 80
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict: expected key and value pairs")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+func pick(m map[string]interface{}, keys ...string) map[string]interface{} {
+	result := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := m[key]; ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func omit(m map[string]interface{}, keys ...string) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		result[key] = value
+	}
+	for _, key := range keys {
+		delete(result, key)
+	}
+	return result
+}
+
+// toYAML encodes a value as YAML with two space indentation and without a
+// trailing newline, for use with nindent.
+func toYAML(value interface{}) (string, error) {
+	var output bytes.Buffer
+	encoder := yaml.NewEncoder(&output)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(output.String(), "\n"), nil
+}
+
+func fromYAML(s string) (interface{}, error) {
+	var value interface{}
+	err := yaml.Unmarshal([]byte(s), &value)
+	return value, err
+}
+
+func toJSON(value interface{}) (string, error) {
+	output, err := json.Marshal(value)
+	return string(output), err
+}
+
+func toPrettyJSON(value interface{}) (string, error) {
+	output, err := json.MarshalIndent(value, "", "  ")
+	return string(output), err
+}
+
+func fromJSON(s string) (interface{}, error) {
+	var value interface{}
+	err := json.Unmarshal([]byte(s), &value)
+	return value, err
+}
+
+func b64dec(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	return string(decoded), err
+}
@@ -0,0 +1,178 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// render executes a template with the functions of layer templates.
+func render(t *testing.T, content string, data interface{}) (string, error) {
+	t.Helper()
+	tmpl, err := parseTemplate(content, templateFuncs(nil))
+	if err != nil {
+		return "", err
+	}
+	var output bytes.Buffer
+	err = tmpl.Execute(&output, data)
+	return output.String(), err
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"Function": "postgresql",
+		"Instance": "7",
+		"Empty":    "",
+		"Ports":    []interface{}{8102, 8103},
+		"Contact":  map[string]interface{}{"phone": "555-1234", "support": "support@example.com"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		// Strings
+		{"default for empty", `{{ .Empty | default "none" }}`, "none"},
+		{"default for missing", `{{ .Missing | default "none" }}`, "none"},
+		{"default keeps value", `{{ .Function | default "none" }}`, "postgresql"},
+		{"empty", `{{ empty .Empty }} {{ empty .Ports }}`, "true false"},
+		{"coalesce", `{{ coalesce .Missing .Empty .Function }}`, "postgresql"},
+		{"upper lower title", `{{ upper .Function }} {{ lower "ABC" }} {{ title "web-server one" }}`, "POSTGRESQL abc Web-Server One"},
+		{"trim", `{{ trim "  a  " }}|{{ trimPrefix "post" .Function }}|{{ trimSuffix "sql" .Function }}`, "a|gresql|postgre"},
+		{"replace", `{{ .Function | replace "sql" "-db" }}`, "postgre-db"},
+		{"contains and prefixes", `{{ contains "gres" .Function }} {{ hasPrefix "post" .Function }} {{ hasSuffix "x" .Function }}`, "true true false"},
+		{"repeat", `{{ repeat 3 "ab" }}`, "ababab"},
+		{"split and join", `{{ split "." "a.b.c" | join "," }}`, "a,b,c"},
+		{"join numbers", `{{ join ":" .Ports }}`, "8102:8103"},
+		{"quote", `{{ quote .Function }} {{ squote "it's" }}`, `"postgresql" 'it''s'`},
+		{"indent", `{{ "a: 1\nb: 2" | indent 2 }}`, "  a: 1\n  b: 2"},
+		{"nindent", `x:{{ "a: 1" | nindent 2 }}`, "x:\n  a: 1"},
+		{"regex", `{{ regexMatch "^post" .Function }} {{ regexFind "[0-9]+" "db12x" }} {{ regexReplaceAll "[aeiou]" "_" .Function }}`, "true 12 p_stgr_sql"},
+
+		// Conversion and math
+		{"atoi", `{{ add (atoi .Instance) 1 }}`, "8"},
+		{"arithmetic on captures", `{{ add .Instance 10 }} {{ sub .Instance 2 }} {{ mul .Instance 3 }} {{ div .Instance 2 }} {{ mod .Instance 4 }}`, "17 5 21 3 3"},
+		{"min max", `{{ max 3 .Instance }} {{ min 3 .Instance }}`, "7 3"},
+		{"floats", `{{ addf 1.5 .Instance }} {{ divf 1 4 }} {{ round 2.5 }}`, "8.5 0.25 3"},
+		{"conversions", `{{ toFloat "1.5" }} {{ toString 12 }} {{ toBool "true" }} {{ toInt 3.9 }}`, "1.5 12 true 3"},
+		{"seq", `{{ range seq 1 3 }}{{ . }}{{ end }}`, "123"},
+
+		// Lists
+		{"list", `{{ list 1 "a" | toJson }}`, `[1,"a"]`},
+		{"first last rest", `{{ first .Ports }} {{ last .Ports }} {{ rest .Ports | toJson }}`, "8102 8103 [8103]"},
+		{"append concat", `{{ append .Ports 8104 | toJson }} {{ concat .Ports (list 1) | toJson }} {{ .Ports | toJson }}`, "[8102,8103,8104] [8102,8103,1] [8102,8103]"},
+		{"uniq has", `{{ uniq (list 1 2 1) | toJson }} {{ has 8102 .Ports }} {{ has "8103" .Ports }} {{ has 1 .Ports }}`, "[1,2] true true false"},
+		{"sortAlpha", `{{ sortAlpha (list "b" "c" "a") | join "" }}`, "abc"},
+
+		// Dicts
+		{"dict", `{{ dict "name" .Function "port" 5432 | toJson }}`, `{"name":"postgresql","port":5432}`},
+		{"get hasKey keys", `{{ get "phone" .Contact }} {{ hasKey "fax" .Contact }} {{ keys .Contact | join "," }}`, "555-1234 false phone,support"},
+		{"merge", `{{ merge .Contact (dict "phone" "555-0000") | toJson }} {{ .Contact.phone }}`, `{"phone":"555-0000","support":"support@example.com"} 555-1234`},
+		{"pick omit", `{{ pick .Contact "phone" | toJson }} {{ omit .Contact "phone" | toJson }}`, `{"phone":"555-1234"} {"support":"support@example.com"}`},
+
+		// Encoding and hashing
+		{"toYaml", `{{ toYaml .Contact }}`, "phone: 555-1234\nsupport: support@example.com"},
+		{"fromYaml", `{{ (fromYaml "a: [1, 2]").a | toJson }}`, "[1,2]"},
+		{"json", `{{ toJson .Contact }} {{ (fromJson "{\"a\": 1}").a }}`, `{"phone":"555-1234","support":"support@example.com"} 1`},
+		{"toPrettyJson", `{{ toPrettyJson (list 1) }}`, "[\n  1\n]"},
+		{"base64", `{{ b64enc "secret" }} {{ b64dec "c2VjcmV0" }}`, "c2VjcmV0 secret"},
+		{"hashes", `{{ md5sum "a" }} {{ sha1sum "a" }} {{ sha256sum "a" }}`,
+			"0cc175b9c0f1b6a831c399e269772661 86f7e437faa5a7fce15d1ddcb9eaeaea377667b8 ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := render(t, tt.template, data)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestTemplateFuncErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"atoi of a word", `{{ atoi "web" }}`},
+		{"division by zero", `{{ div 1 0 }}`},
+		{"modulo by zero", `{{ mod 1 0 }}`},
+		{"join of a scalar", `{{ join "," 1 }}`},
+		{"dict with odd arguments", `{{ dict "a" }}`},
+		{"invalid regex", `{{ regexMatch "(" "a" }}`},
+		{"invalid base64", `{{ b64dec "!" }}`},
+		{"huge seq", `{{ seq 1 100000 }}`},
+		{"overflowing seq", `{{ seq -9223372036854775808 9223372036854775807 }}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := render(t, tt.template, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSeqLimits(t *testing.T) {
+	_, err := seq(math.MinInt, math.MaxInt)
+	assert.Error(t, err)
+	_, err = seq(-10, math.MaxInt)
+	assert.Error(t, err)
+
+	values, err := seq(math.MaxInt-2, math.MaxInt)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{math.MaxInt - 2, math.MaxInt - 1, math.MaxInt}, values)
+
+	values, err = seq(math.MinInt, math.MinInt+1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{math.MinInt, math.MinInt + 1}, values)
+
+	values, err = seq(3, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestTemplateFuncsInLayers(t *testing.T) {
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{
+		"all.yaml":             "port: {{ add 8000 .Instance }}\nname: {{ .Function | upper }}\n",
+		"datacenters/slc.yaml": "servers:{{ dict \"ntp\" (list \"ntp1\" \"ntp2\") | toYaml | nindent 2 }}\n",
+	})
+	snapshot := &Snapshot{Path: repo, Hierarchy: DefaultHierarchy}
+	captures := map[string]string{"Function": "web", "Instance": "3", "Datacenter": "slc"}
+
+	layers, err := ResolveLayers(snapshot, "web3", captures)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"port":    8003,
+		"name":    "WEB",
+		"servers": map[string]interface{}{"ntp": []interface{}{"ntp1", "ntp2"}},
+	}, MergeLayers(layers))
+
+	// ProcessTemplate and validation know the same functions
+	_, err = ProcessTemplate(filepath.Join(repo, "all.yaml"), captures)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateSnapshot(&Snapshot{Path: repo, Hierarchy: DefaultHierarchy}, nil))
+}
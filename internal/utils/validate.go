@@ -0,0 +1,169 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// ValidationError lists every problem found in a commit that was refused.
+type ValidationError struct {
+	Commit   string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("commit %s failed validation: %s", e.Commit, strings.Join(e.Problems, "; "))
+}
+
+// ValidateSnapshot checks a snapshot before it is activated: every domain
+// pattern must compile, every YAML file and every encrypted value in it
+// must decrypt, every file a hierarchy level can resolve to must parse as a
+// template, the host lists must be readable and every sample hostname must
+// match a pattern and render without errors. Dot-directories such as
+// .github belong to other tools and are not checked.
+func ValidateSnapshot(s *Snapshot, sampleHosts []string) error {
+	var problems []string
+
+	for _, pattern := range s.Patterns {
+		if _, err := regexp.Compile(pattern.Regex); err != nil {
+			problems = append(problems, fmt.Sprintf("domains_regex.yaml: pattern %s: %v", pattern.Name, err))
+		}
+	}
+
+	layers, err := layerPatterns(s.Hierarchy)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	err = filepath.WalkDir(s.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != s.Path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isYAMLFile(path) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.Path, path)
+		content, err = GlobalSecretKeys.decryptFile(content)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: encrypted file: %v", rel, err))
+			return nil
+		}
+		for _, problem := range GlobalSecretKeys.checkValues(content) {
+			problems = append(problems, fmt.Sprintf("%s: %s", rel, problem))
+		}
+		if !matchesLayer(layers, filepath.ToSlash(rel)) {
+			return nil
+		}
+		if _, err := parseTemplate(string(content), templateFuncs(nil)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", rel, err))
+		}
+		return nil
+	})
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := KnownHosts(s.Path); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	// Rendering only makes sense once the patterns and templates are sound
+	if len(problems) == 0 {
+		for _, hostname := range sampleHosts {
+			if err := validateHost(s, hostname); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", hostname, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Commit: s.Commit, Problems: problems}
+	}
+	return nil
+}
+
+// validateHost renders a hostname the same way the details endpoint does.
+func validateHost(s *Snapshot, hostname string) error {
+	match, err := MatchHostname(hostname, s.Patterns)
+	if err != nil {
+		return err
+	}
+	if match == nil {
+		return fmt.Errorf("no matching pattern found")
+	}
+	_, err = ResolveLayers(s, hostname, match.Captures)
+	return err
+}
+
+// layerPatterns returns a regular expression for every hierarchy level that
+// matches the paths the level can resolve to, whatever the captures are.
+func layerPatterns(levels []HierarchyLevel) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(levels))
+	for _, level := range levels {
+		tmpl, err := template.New(level.Name).Parse(level.Path)
+		if err != nil {
+			return nil, fmt.Errorf("hierarchy level %s: %w", level.Name, err)
+		}
+		var pattern strings.Builder
+		pattern.WriteString("^")
+		for _, node := range tmpl.Tree.Root.Nodes {
+			if text, ok := node.(*parse.TextNode); ok {
+				pattern.WriteString(regexp.QuoteMeta(string(text.Text)))
+			} else {
+				pattern.WriteString(".*")
+			}
+		}
+		pattern.WriteString("$")
+		patterns = append(patterns, regexp.MustCompile(pattern.String()))
+	}
+	return patterns, nil
+}
+
+func matchesLayer(patterns []*regexp.Regexp, path string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func isYAMLFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
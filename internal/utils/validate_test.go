@@ -0,0 +1,111 @@
+/*
+This file is part of configNexus.
+
+configNexus is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+configNexus is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with configNexus.  If not, see <https://www.gnu.org/licenses/>.
+
+Copyright (C) 2023 Operistech Inc.
+*/
+
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidateSnapshot(t *testing.T) {
+	patterns := []RegexPattern{{Name: "test", Regex: "^(?P<Function>[a-z]+)(?P<Instance>\\d+)$"}}
+
+	tests := []struct {
+		name        string
+		files       map[string]string
+		patterns    []RegexPattern
+		sampleHosts []string
+		problems    []string // Expected prefix of each problem
+	}{
+		{
+			name: "valid commit",
+			files: map[string]string{
+				"all.yaml":          "function: {{ .Function }}\n",
+				"functions/web.yml": "instance: {{ .Instance }}\n",
+				"README.md":         "{{ not a template",
+			},
+			patterns:    patterns,
+			sampleHosts: []string{"web1"},
+		},
+		{
+			name: "files no layer uses",
+			files: map[string]string{
+				"all.yaml":                 "a: 1\n",
+				".github/workflows/ci.yml": "on: push\njobs:\n  test:\n    if: ${{ github.ref == 'refs/heads/main' }}\n",
+				"docs/example.yaml":        "a: {{ not a template\n",
+			},
+			patterns:    patterns,
+			sampleHosts: []string{"web1"},
+		},
+		{
+			name:     "invalid regex",
+			files:    map[string]string{"all.yaml": "a: 1\n"},
+			patterns: []RegexPattern{{Name: "broken", Regex: "(invalid"}},
+			problems: []string{"domains_regex.yaml: pattern broken: error parsing regexp"},
+		},
+		{
+			name: "template syntax error",
+			files: map[string]string{
+				"all.yaml":             "a: 1\n",
+				"datacenters/slc.yaml": "a: {{ .Datacenter\n",
+			},
+			patterns: patterns,
+			problems: []string{"datacenters/slc.yaml: template: config:"},
+		},
+		{
+			name:        "sample host without pattern",
+			files:       map[string]string{"all.yaml": "a: 1\n"},
+			patterns:    patterns,
+			sampleHosts: []string{"UNKNOWN"},
+			problems:    []string{"UNKNOWN: no matching pattern found"},
+		},
+		{
+			name:        "sample host that fails to render",
+			files:       map[string]string{"all.yaml": "a: [1\n"},
+			patterns:    patterns,
+			sampleHosts: []string{"web1"},
+			problems:    []string{"web1: layer common (all.yaml): yaml:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			snapshot := &Snapshot{Commit: "abc", Path: dir, Patterns: tt.patterns, Hierarchy: DefaultHierarchy}
+
+			err := ValidateSnapshot(snapshot, tt.sampleHosts)
+			if tt.problems == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *ValidationError
+			assert.True(t, errors.As(err, &validationErr))
+			assert.Equal(t, "abc", validationErr.Commit)
+			assert.Len(t, validationErr.Problems, len(tt.problems))
+			for i, problem := range validationErr.Problems {
+				assert.True(t, strings.HasPrefix(problem, tt.problems[i]), problem)
+			}
+		})
+	}
+}